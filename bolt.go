@@ -0,0 +1,288 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltEntries = []byte("entries")
+	// boltTables holds a bucket of keys by table, and boltTags by tag
+	boltTables = []byte("tables")
+	boltTags   = []byte("tags")
+	// boltExpiries holds the keys of the entries which expire, after their expiry, in order.
+	// The keys are the hashes of the identifiers, which are kept in the header of the entries
+	boltExpiries = []byte("expiries")
+)
+
+// BoltCacher is a Cacher keeping its entries in a bbolt database, a single file surviving restarts.
+// The entries are stored by the hash of their key, which bbolt limits to 32KB,
+// and are indexed by table, tag and expiry, so that they are invalidated or swept in a single transaction
+type BoltCacher struct {
+	db  *bolt.DB
+	now func() time.Time
+	janitor
+}
+
+// NewBoltCacher opens the database at path, which is created when missing.
+// Expired entries are dropped when read, by Sweep, and every janitorInterval unless it is zero.
+// Close stops the janitor and closes the database
+func NewBoltCacher(path string, janitorInterval time.Duration) (*BoltCacher, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		return createBoltBuckets(tx)
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	c := &BoltCacher{db: db, now: time.Now, janitor: newJanitor()}
+	c.janitor.start(janitorInterval, func() {
+		_ = c.Sweep(context.Background())
+	})
+	return c, nil
+}
+
+func createBoltBuckets(tx *bolt.Tx) error {
+	for _, name := range [][]byte{boltEntries, boltTables, boltTags, boltExpiries} {
+		if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *BoltCacher) Get(_ context.Context, key string, q *Query[any]) (*Query[any], error) {
+	var data []byte
+	err := c.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(boltEntries).Get(boltKey(key)); v != nil {
+			data = bytes.Clone(v)
+		}
+		return nil
+	})
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	header, value, err := decodeFileEntry(data)
+	if err != nil {
+		return nil, err
+	}
+	if header.key != key {
+		// A collision of hashes, as unlikely as it is
+		return nil, nil
+	}
+	if now := c.now(); header.expired(now) {
+		return nil, c.db.Update(func(tx *bolt.Tx) error {
+			// Unless it was replaced in the meantime
+			if data := tx.Bucket(boltEntries).Get(boltKey(key)); data != nil {
+				if header, _, err := decodeFileEntry(data); err == nil && !header.expired(now) {
+					return nil
+				}
+			}
+			return c.delete(tx, boltKey(key))
+		})
+	}
+	if err := q.Unmarshal(value); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Store replaces the entry of key along with its indexes, in a transaction
+func (c *BoltCacher) Store(_ context.Context, key string, val *Query[any], d ...time.Duration) error {
+	value, err := val.Marshal()
+	if err != nil {
+		return err
+	}
+	header := fileHeader{key: key, tables: val.Metadata().Tables, tags: val.Metadata().Tags}
+	if len(d) > 0 && d[0] > 0 {
+		header.expires = c.now().Add(d[0])
+	}
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		k := boltKey(key)
+		if err := c.delete(tx, k); err != nil {
+			return err
+		}
+		if err := tx.Bucket(boltEntries).Put(k, encodeFileEntry(header, value)); err != nil {
+			return err
+		}
+		if err := indexBolt(tx.Bucket(boltTables), header.tables, k); err != nil {
+			return err
+		}
+		if err := indexBolt(tx.Bucket(boltTags), header.tags, k); err != nil {
+			return err
+		}
+		if !header.expires.IsZero() {
+			return tx.Bucket(boltExpiries).Put(expiryKey(header.expires, k), nil)
+		}
+		return nil
+	})
+}
+
+// Invalidate drops every entry
+func (c *BoltCacher) Invalidate(context.Context) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltEntries, boltTables, boltTags, boltExpiries} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return createBoltBuckets(tx)
+	})
+}
+
+func (c *BoltCacher) Delete(_ context.Context, key string) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return c.delete(tx, boltKey(key))
+	})
+}
+
+func (c *BoltCacher) InvalidateTags(_ context.Context, tags ...string) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return c.deleteIndexed(tx, boltTags, tags)
+	})
+}
+
+// InvalidateTables drops the entries of the queries which read any of the tables
+func (c *BoltCacher) InvalidateTables(_ context.Context, tables ...string) error {
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return c.deleteIndexed(tx, boltTables, tables)
+	})
+}
+
+// Sweep drops the expired entries
+func (c *BoltCacher) Sweep(context.Context) error {
+	now := c.now()
+	return c.db.Update(func(tx *bolt.Tx) error {
+		var keys [][]byte
+		cursor := tx.Bucket(boltExpiries).Cursor()
+		for k, _ := cursor.First(); k != nil; k, _ = cursor.Next() {
+			if !now.After(time.Unix(0, int64(binary.BigEndian.Uint64(k)))) {
+				break
+			}
+			keys = append(keys, bytes.Clone(k[8:]))
+		}
+		for _, key := range keys {
+			if err := c.delete(tx, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Len returns the number of entries, including the expired ones which were not dropped yet
+func (c *BoltCacher) Len() int {
+	n := 0
+	_ = c.db.View(func(tx *bolt.Tx) error {
+		n = tx.Bucket(boltEntries).Stats().KeyN
+		return nil
+	})
+	return n
+}
+
+// Close stops the janitor and closes the database
+func (c *BoltCacher) Close() error {
+	_ = c.janitor.Close()
+	return c.db.Close()
+}
+
+// deleteIndexed drops the entries in the buckets of the names, along with the buckets
+func (c *BoltCacher) deleteIndexed(tx *bolt.Tx, index []byte, names []string) error {
+	for _, name := range names {
+		b := tx.Bucket(index).Bucket([]byte(name))
+		if b == nil {
+			continue
+		}
+		var keys [][]byte
+		if err := b.ForEach(func(k, _ []byte) error {
+			keys = append(keys, bytes.Clone(k))
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := c.delete(tx, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// delete drops the entry of the hash of a key along with its indexes, the buckets left empty are dropped
+func (c *BoltCacher) delete(tx *bolt.Tx, key []byte) error {
+	entries := tx.Bucket(boltEntries)
+	data := entries.Get(key)
+	if data == nil {
+		return nil
+	}
+	header, _, err := decodeFileEntry(data)
+	if err == nil {
+		if err := unindexBolt(tx.Bucket(boltTables), header.tables, key); err != nil {
+			return err
+		}
+		if err := unindexBolt(tx.Bucket(boltTags), header.tags, key); err != nil {
+			return err
+		}
+		if !header.expires.IsZero() {
+			if err := tx.Bucket(boltExpiries).Delete(expiryKey(header.expires, key)); err != nil {
+				return err
+			}
+		}
+	}
+	return entries.Delete(key)
+}
+
+func indexBolt(index *bolt.Bucket, names []string, key []byte) error {
+	for _, name := range names {
+		b, err := index.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return err
+		}
+		if err := b.Put(key, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unindexBolt(index *bolt.Bucket, names []string, key []byte) error {
+	for _, name := range names {
+		b := index.Bucket([]byte(name))
+		if b == nil {
+			continue
+		}
+		if err := b.Delete(key); err != nil {
+			return err
+		}
+		if k, _ := b.Cursor().First(); k == nil {
+			if err := index.DeleteBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// expiryKey orders the keys by expiry
+func expiryKey(expires time.Time, key []byte) []byte {
+	return append(binary.BigEndian.AppendUint64(nil, uint64(expires.UnixNano())), key...)
+}
+
+// boltKey returns the hash of key, by which its entry is stored and indexed
+func boltKey(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
@@ -0,0 +1,256 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func newBoltCacher(t *testing.T) *BoltCacher {
+	c, err := NewBoltCacher(filepath.Join(t.TempDir(), "cache.db"), 0)
+	if err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+// boltKeys returns the number of keys in the bucket at path
+func boltKeys(t *testing.T, c *BoltCacher, path ...string) int {
+	t.Helper()
+	n := 0
+	_ = c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(path[0]))
+		for _, name := range path[1:] {
+			if b == nil {
+				return nil
+			}
+			b = b.Bucket([]byte(name))
+		}
+		if b != nil {
+			n = b.Stats().KeyN
+		}
+		return nil
+	})
+	return n
+}
+
+func TestBoltCacher(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("store and get", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cache.db")
+		c, err := NewBoltCacher(path, 0)
+		if err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if _, ok := lruGet(t, c, "a"); ok {
+			t.Error("a miss was expected")
+		}
+		if err := c.Store(ctx, "a", lruQuery(42)); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if val, ok := lruGet(t, c, "a"); !ok || val != 42 {
+			t.Errorf("expected 42 to be returned, got %d, %t", val, ok)
+		}
+		_ = c.Close()
+
+		// Outlives the BoltCacher
+		c, err = NewBoltCacher(path, 0)
+		if err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		defer c.Close()
+		if val, ok := lruGet(t, c, "a"); !ok || val != 42 {
+			t.Errorf("expected 42 to be returned once reopened, got %d, %t", val, ok)
+		}
+
+		_ = c.Delete(ctx, "a")
+		if _, ok := lruGet(t, c, "a"); ok {
+			t.Error("the entry was expected to be deleted")
+		}
+		if n := c.Len(); n != 0 {
+			t.Errorf("expected no entry to be left, got %d", n)
+		}
+	})
+
+	t.Run("long key", func(t *testing.T) {
+		c := newBoltCacher(t)
+		// Beyond the 32KB keys of bbolt
+		long := "SELECT * FROM users WHERE " + strings.Repeat("id = 1 OR ", 5000) + "id = 2"
+		if err := c.Store(ctx, long, fileQuery(1, []string{"users"}, []string{"tenant:a"}), time.Hour); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if val, ok := lruGet(t, c, long); !ok || val != 1 {
+			t.Errorf("expected 1 to be returned for a long key, got %d, %t", val, ok)
+		}
+		if _, ok := lruGet(t, c, long[:len(long)-1]); ok {
+			t.Error("a miss was expected for another key")
+		}
+
+		// Another key under the same hash
+		_ = c.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(boltEntries).Put(boltKey("a"), encodeFileEntry(fileHeader{key: "b"}, nil))
+		})
+		if _, ok := lruGet(t, c, "a"); ok {
+			t.Error("a miss was expected for a collision")
+		}
+
+		_ = c.InvalidateTags(ctx, "tenant:a")
+		if n := c.Len(); n != 1 {
+			t.Errorf("expected the long key to be invalidated, got %d entries", n)
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		c := newBoltCacher(t)
+		now := time.Now()
+		c.now = func() time.Time { return now }
+
+		_ = c.Store(ctx, "short", lruQuery(1), time.Second)
+		_ = c.Store(ctx, "long", lruQuery(2), time.Hour)
+		_ = c.Store(ctx, "forever", lruQuery(3))
+		if n := boltKeys(t, c, "expiries"); n != 2 {
+			t.Errorf("expected 2 entries in the TTL index, got %d", n)
+		}
+		now = now.Add(time.Minute)
+
+		if _, ok := lruGet(t, c, "short"); ok {
+			t.Error("the expired entry was not expected to be returned")
+		}
+		if n := c.Len(); n != 2 {
+			t.Errorf("the expired entry was expected to be dropped when read, got %d entries", n)
+		}
+
+		now = now.Add(2 * time.Hour)
+		if err := c.Sweep(ctx); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if n := c.Len(); n != 1 {
+			t.Errorf("the expired entries were expected to be swept, got %d entries", n)
+		}
+		if n := boltKeys(t, c, "expiries"); n != 0 {
+			t.Errorf("expected the TTL index to be emptied, got %d", n)
+		}
+		if _, ok := lruGet(t, c, "forever"); !ok {
+			t.Error("the entry without TTL was expected to be kept")
+		}
+	})
+
+	t.Run("replaced", func(t *testing.T) {
+		c := newBoltCacher(t)
+		_ = c.Store(ctx, "a", fileQuery(1, []string{"users"}, []string{"tenant:a"}), time.Hour)
+		_ = c.Store(ctx, "a", fileQuery(2, []string{"pets"}, nil))
+
+		if val, ok := lruGet(t, c, "a"); !ok || val != 2 {
+			t.Errorf("expected 2 to be returned, got %d, %t", val, ok)
+		}
+		if n := boltKeys(t, c, "expiries") + boltKeys(t, c, "tables", "users") + boltKeys(t, c, "tags", "tenant:a"); n != 0 {
+			t.Errorf("expected the indexes of the previous entry to be dropped, got %d keys", n)
+		}
+		if n := boltKeys(t, c, "tables", "pets"); n != 1 {
+			t.Errorf("expected the entry to be indexed by its table, got %d keys", n)
+		}
+	})
+
+	t.Run("janitor", func(t *testing.T) {
+		c, err := NewBoltCacher(filepath.Join(t.TempDir(), "cache.db"), time.Millisecond)
+		if err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		defer c.Close()
+		_ = c.Store(ctx, "a", lruQuery(1), time.Millisecond)
+
+		deadline := time.Now().Add(time.Second)
+		for c.Len() != 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if n := c.Len(); n != 0 {
+			t.Errorf("the janitor was expected to sweep the expired entry, got %d entries", n)
+		}
+	})
+
+	t.Run("invalidation", func(t *testing.T) {
+		c := newBoltCacher(t)
+		store := func() {
+			_ = c.Store(ctx, "users:1", fileQuery(1, []string{"users"}, []string{"tenant:a"}))
+			_ = c.Store(ctx, "users:2", fileQuery(2, []string{"users", "pets"}, []string{"tenant:b"}), time.Hour)
+			_ = c.Store(ctx, "pets:1", fileQuery(3, []string{"pets"}, nil))
+		}
+		kept := func(keys ...string) {
+			t.Helper()
+			assertKept(t, c, []string{"users:1", "users:2", "pets:1"}, keys...)
+		}
+
+		store()
+		_ = c.InvalidateTables(ctx, "pets")
+		kept("users:1")
+		if n := boltKeys(t, c, "tables", "users") + boltKeys(t, c, "tags", "tenant:b") + boltKeys(t, c, "expiries"); n != 1 {
+			t.Errorf("expected the indexes of the dropped entries to be dropped, got %d keys", n)
+		}
+
+		store()
+		_ = c.InvalidateTags(ctx, "tenant:b")
+		kept("users:1", "pets:1")
+
+		store()
+		_ = c.Invalidate(ctx)
+		kept()
+		if n := boltKeys(t, c, "tables", "users") + boltKeys(t, c, "expiries"); n != 0 {
+			t.Errorf("expected the indexes to be emptied, got %d keys", n)
+		}
+	})
+
+	t.Run("invalid entries", func(t *testing.T) {
+		c := newBoltCacher(t)
+		_ = c.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(boltEntries).Put(boltKey("a"), []byte("garbage"))
+		})
+		if _, err := c.Get(ctx, "a", &Query[any]{}); !errors.Is(err, ErrInvalidEntry) {
+			t.Errorf("expected ErrInvalidEntry, got %v", err)
+		}
+		if err := c.Delete(ctx, "a"); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if n := c.Len(); n != 0 {
+			t.Errorf("expected the invalid entry to be deleted, got %d entries", n)
+		}
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		c := newBoltCacher(t)
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					key := fmt.Sprintf("key-%d", (i+j)%5)
+					if err := c.Store(ctx, key, lruQuery(j, key)); err != nil {
+						t.Errorf("an unexpected error has occurred, %v", err)
+						return
+					}
+					if j%5 == 0 {
+						if err := c.InvalidateTags(ctx, key); err != nil {
+							t.Errorf("an unexpected error has occurred, %v", err)
+							return
+						}
+					}
+					var dest int
+					if _, err := c.Get(ctx, key, &Query[any]{Dest: &dest}); err != nil {
+						t.Errorf("an unexpected error has occurred, %v", err)
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+	})
+}
@@ -0,0 +1,237 @@
+package cache
+
+import (
+	"container/heap"
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// BoundedCacher is an in-memory Cacher bounded by the total size of its entries,
+// each one is charged the size of its serialized Query along with its key.
+// The least valuable entries are evicted first, the value of an entry being its hits by byte,
+// aged so that entries which were popular long ago are evicted in the end (GreedyDual-Size-Frequency)
+type BoundedCacher struct {
+	mu            sync.Mutex
+	maxBytes      int64
+	maxEntryBytes int64
+	size          int64
+	entries       map[string]*boundedEntry
+	queue         boundedQueue
+	// age is the priority of the last evicted entry, it is added to the priority of the others
+	age float64
+	// admission decides whether new entries may evict the others, look at WithAdmission
+	admission AdmissionPolicy
+
+	now func() time.Time
+	janitor
+}
+
+type boundedEntry struct {
+	lruEntry
+	cost     int64
+	hits     float64
+	priority float64
+	index    int
+}
+
+// NewBoundedCacher returns a BoundedCacher holding at most maxBytes bytes,
+// which refuses entries larger than maxEntryFraction of it, 1 when it is not in (0, 1].
+// Expired entries are dropped when read and every janitorInterval, unless it is zero.
+// Close stops the janitor
+func NewBoundedCacher(maxBytes int64, maxEntryFraction float64, janitorInterval time.Duration) *BoundedCacher {
+	if maxEntryFraction <= 0 || maxEntryFraction > 1 {
+		maxEntryFraction = 1
+	}
+	c := &BoundedCacher{
+		maxBytes:      maxBytes,
+		maxEntryBytes: int64(float64(maxBytes) * maxEntryFraction),
+		entries:       make(map[string]*boundedEntry),
+		now:           time.Now,
+		janitor:       newJanitor(),
+	}
+	c.janitor.start(janitorInterval, c.dropExpired)
+	return c
+}
+
+// WithAdmission makes the new entries only evict the others if policy admits them, see NewTinyLFU.
+// It must be called before the BoundedCacher is used
+func (c *BoundedCacher) WithAdmission(policy AdmissionPolicy) *BoundedCacher {
+	c.admission = policy
+	return c
+}
+
+func (c *BoundedCacher) Get(_ context.Context, key string, q *Query[any]) (*Query[any], error) {
+	if c.admission != nil {
+		c.admission.Record(key)
+	}
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil, nil
+	}
+	if entry.expired(c.now()) {
+		c.remove(entry)
+		c.mu.Unlock()
+		return nil, nil
+	}
+	entry.hits++
+	c.prioritize(entry)
+	heap.Fix(&c.queue, entry.index)
+	value := entry.value
+	c.mu.Unlock()
+
+	if err := q.Unmarshal(value); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Store keeps val until d[0] is elapsed, if any,
+// entries larger than the limit are not kept, they replace the previous entry nevertheless
+func (c *BoundedCacher) Store(_ context.Context, key string, val *Query[any], d ...time.Duration) error {
+	value, err := val.Marshal()
+	if err != nil {
+		return err
+	}
+	entry := &boundedEntry{
+		lruEntry: lruEntry{key: key, value: value, tags: val.Metadata().Tags},
+		cost:     int64(len(key) + len(value)),
+		hits:     1,
+	}
+	if len(d) > 0 && d[0] > 0 {
+		entry.expires = c.now().Add(d[0])
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if previous, ok := c.entries[key]; ok {
+		entry.hits += previous.hits
+		c.remove(previous)
+	}
+	if entry.cost > c.maxEntryBytes {
+		return nil
+	}
+	var victims []*boundedEntry
+	for c.size+entry.cost > c.maxBytes && c.queue.Len() > 0 {
+		victim := heap.Pop(&c.queue).(*boundedEntry)
+		c.size -= victim.cost
+		victims = append(victims, victim)
+		if c.admission != nil && !c.admission.Admit(key, victim.key) {
+			// Refused, the victims are kept
+			for _, victim := range victims {
+				heap.Push(&c.queue, victim)
+				c.size += victim.cost
+			}
+			return nil
+		}
+	}
+	for _, victim := range victims {
+		c.age = victim.priority
+		delete(c.entries, victim.key)
+	}
+
+	c.prioritize(entry)
+	c.entries[key] = entry
+	heap.Push(&c.queue, entry)
+	c.size += entry.cost
+	return nil
+}
+
+func (c *BoundedCacher) Invalidate(context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*boundedEntry)
+	c.queue = nil
+	c.size = 0
+	c.age = 0
+	return nil
+}
+
+func (c *BoundedCacher) Delete(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok {
+		c.remove(entry)
+	}
+	return nil
+}
+
+func (c *BoundedCacher) InvalidateTags(_ context.Context, tags ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, entry := range c.entries {
+		for _, tag := range entry.tags {
+			if slices.Contains(tags, tag) {
+				c.remove(entry)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Len returns the number of entries, including the expired ones which were not dropped yet
+func (c *BoundedCacher) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Size returns the bytes the entries are charged
+func (c *BoundedCacher) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+func (c *BoundedCacher) dropExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for _, entry := range c.entries {
+		if entry.expired(now) {
+			c.remove(entry)
+		}
+	}
+}
+
+func (c *BoundedCacher) prioritize(entry *boundedEntry) {
+	entry.priority = c.age + entry.hits/float64(entry.cost)
+}
+
+// remove must be called with mu held
+func (c *BoundedCacher) remove(entry *boundedEntry) {
+	heap.Remove(&c.queue, entry.index)
+	delete(c.entries, entry.key)
+	c.size -= entry.cost
+}
+
+// boundedQueue is a heap of the entries, the least valuable first
+type boundedQueue []*boundedEntry
+
+func (q boundedQueue) Len() int { return len(q) }
+
+func (q boundedQueue) Less(i, j int) bool { return q[i].priority < q[j].priority }
+
+func (q boundedQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *boundedQueue) Push(x any) {
+	entry := x.(*boundedEntry)
+	entry.index = len(*q)
+	*q = append(*q, entry)
+}
+
+func (q *boundedQueue) Pop() any {
+	old := *q
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return entry
+}
@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func boundedQuery(size int, tags ...string) *Query[any] {
+	return &Query[any]{Dest: strings.Repeat("x", size), RowsAffected: 1, meta: Metadata{Tags: tags}}
+}
+
+func boundedGet(t *testing.T, c Cacher, key string) bool {
+	t.Helper()
+	var dest string
+	res, err := c.Get(context.Background(), key, &Query[any]{Dest: &dest})
+	if err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	return res != nil
+}
+
+func TestBoundedCacher(t *testing.T) {
+	ctx := context.Background()
+	entrySize := func(key string, q *Query[any]) int64 {
+		bytes, _ := q.Marshal()
+		return int64(len(key) + len(bytes))
+	}
+
+	t.Run("cost accounting", func(t *testing.T) {
+		c := NewBoundedCacher(1<<20, 0, 0)
+		_ = c.Store(ctx, "a", boundedQuery(100))
+		_ = c.Store(ctx, "b", boundedQuery(200))
+		expected := entrySize("a", boundedQuery(100)) + entrySize("b", boundedQuery(200))
+		if c.Size() != expected {
+			t.Errorf("expected the entries to be charged %d bytes, got %d", expected, c.Size())
+		}
+
+		_ = c.Store(ctx, "a", boundedQuery(300))
+		expected = entrySize("a", boundedQuery(300)) + entrySize("b", boundedQuery(200))
+		if c.Size() != expected {
+			t.Errorf("expected the replaced entry to be charged %d bytes, got %d", expected, c.Size())
+		}
+
+		_ = c.Delete(ctx, "a")
+		if expected = entrySize("b", boundedQuery(200)); c.Size() != expected {
+			t.Errorf("expected the deleted entry to be refunded, got %d bytes instead of %d", c.Size(), expected)
+		}
+		_ = c.Invalidate(ctx)
+		if c.Size() != 0 || c.Len() != 0 {
+			t.Errorf("expected no entry to be kept, got %d entries of %d bytes", c.Len(), c.Size())
+		}
+	})
+
+	t.Run("budget", func(t *testing.T) {
+		budget := 10 * entrySize("key-00", boundedQuery(100))
+		c := NewBoundedCacher(budget, 0, 0)
+		for i := 0; i < 50; i++ {
+			_ = c.Store(ctx, fmt.Sprintf("key-%02d", i), boundedQuery(100))
+			if c.Size() > budget {
+				t.Fatalf("expected at most %d bytes, got %d", budget, c.Size())
+			}
+		}
+		if c.Len() != 10 {
+			t.Errorf("expected 10 entries, got %d", c.Len())
+		}
+	})
+
+	t.Run("least valuable", func(t *testing.T) {
+		budget := 4 * entrySize("key-0", boundedQuery(100))
+		c := NewBoundedCacher(budget, 0, 0)
+		_ = c.Store(ctx, "hot", boundedQuery(100))
+		for i := 0; i < 20; i++ {
+			boundedGet(t, c, "hot")
+			_ = c.Store(ctx, fmt.Sprintf("key-%d", i), boundedQuery(100))
+		}
+		if !boundedGet(t, c, "hot") {
+			t.Error("the frequently read entry was expected to be kept")
+		}
+		if boundedGet(t, c, "key-0") {
+			t.Error("the oldest entry which was never read was expected to be evicted")
+		}
+
+		// At the same hits, larger entries are less valuable
+		c = NewBoundedCacher(entrySize("small", boundedQuery(100))+entrySize("large", boundedQuery(1000))+10, 0, 0)
+		_ = c.Store(ctx, "small", boundedQuery(100))
+		_ = c.Store(ctx, "large", boundedQuery(1000))
+		_ = c.Store(ctx, "other", boundedQuery(100))
+		if !boundedGet(t, c, "small") || boundedGet(t, c, "large") {
+			t.Error("the large entry was expected to be evicted")
+		}
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		c := NewBoundedCacher(1000, 0.1, 0)
+		_ = c.Store(ctx, "small", boundedQuery(10))
+		_ = c.Store(ctx, "large", boundedQuery(10))
+		if err := c.Store(ctx, "large", boundedQuery(200)); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if boundedGet(t, c, "large") {
+			t.Error("the entry above the fraction of the budget was not expected to be kept, nor the previous one")
+		}
+		if !boundedGet(t, c, "small") {
+			t.Error("the other entries were not expected to be evicted")
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		c := NewBoundedCacher(1<<20, 0, 0)
+		now := time.Now()
+		c.now = func() time.Time { return now }
+		_ = c.Store(ctx, "short", boundedQuery(10), time.Second)
+		_ = c.Store(ctx, "forever", boundedQuery(10))
+		now = now.Add(time.Minute)
+
+		if boundedGet(t, c, "short") {
+			t.Error("the expired entry was not expected to be returned")
+		}
+		if !boundedGet(t, c, "forever") {
+			t.Error("the entry without TTL was expected to be kept")
+		}
+		c.dropExpired()
+		if c.Len() != 1 || c.Size() != entrySize("forever", boundedQuery(10)) {
+			t.Errorf("expected only the entry without TTL to be kept, got %d entries of %d bytes", c.Len(), c.Size())
+		}
+	})
+
+	t.Run("tags", func(t *testing.T) {
+		c := NewBoundedCacher(1<<20, 0, 0)
+		_ = c.Store(ctx, "a", boundedQuery(10, "tenant:a"))
+		_ = c.Store(ctx, "b", boundedQuery(10, "tenant:b"))
+		_ = c.InvalidateTags(ctx, "tenant:a")
+		if boundedGet(t, c, "a") || !boundedGet(t, c, "b") {
+			t.Error("only the tagged entry was expected to be invalidated")
+		}
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		budget := int64(20 << 10)
+		c := NewBoundedCacher(budget, 0.25, time.Millisecond)
+		defer c.Close()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 500; j++ {
+					key := fmt.Sprintf("key-%d", (i*j)%100)
+					switch j % 7 {
+					case 0:
+						_ = c.Delete(ctx, key)
+					case 1:
+						_ = c.InvalidateTags(ctx, key)
+					default:
+						_ = c.Store(ctx, key, boundedQuery(j*4, key), time.Duration(j%3)*time.Millisecond)
+					}
+					var dest string
+					_, _ = c.Get(ctx, key, &Query[any]{Dest: &dest})
+				}
+			}(i)
+		}
+		wg.Wait()
+		if c.Size() > budget {
+			t.Errorf("expected at most %d bytes, got %d", budget, c.Size())
+		}
+	})
+}
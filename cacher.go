@@ -7,12 +7,31 @@ import (
 
 type Cacher interface {
 	// Get impl should check if a specific key exists in the cache and return its value
-	// look at Query.Marshal
+	// unmarshalled into q, which carries the Codec of the Config
+	// look at Query.Unmarshal
 	Get(ctx context.Context, key string, q *Query[any]) (*Query[any], error)
 	// Store impl should store a cached representation of the val param
-	// look at Query.Unmarshal
+	// look at Query.Marshal
 	Store(ctx context.Context, key string, val *Query[any], d ...time.Duration) error
 	// Invalidate impl should invalidate all cached values
 	// It will be called when INSERT / UPDATE / DELETE queries are sent to the DB
 	Invalidate(ctx context.Context) error
 }
+
+// Deleter can be implemented by a Cacher to drop a single entry,
+// it is used to get rid of the entries which can not be read anymore
+type Deleter interface {
+	Delete(ctx context.Context, key string) error
+}
+
+// TagInvalidator can be implemented by a Cacher to invalidate a subset of its entries,
+// the tags of an entry are listed by the Metadata of the Query given to Store
+type TagInvalidator interface {
+	InvalidateTags(ctx context.Context, tags ...string) error
+}
+
+// Releaser can be implemented by a Cacher which has the lookups of a missed key wait for it to be stored,
+// it is told when the query of the key will not store it, because it failed or its result is not cached
+type Releaser interface {
+	Release(ctx context.Context, key string) error
+}
@@ -3,7 +3,9 @@ package cache
 import (
 	"context"
 	"errors"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -64,3 +66,104 @@ func (c *cacherGetErrorMock) Store(context.Context, string, *Query[any], ...time
 func (c *cacherGetErrorMock) Invalidate(context.Context) error {
 	return nil
 }
+
+// cacherReleaseMock records the keys released, Store failing with storeErr when set
+type cacherReleaseMock struct {
+	cacherMock
+	storeErr error
+	released sync.Map
+}
+
+func (c *cacherReleaseMock) Store(ctx context.Context, key string, val *Query[any], d ...time.Duration) error {
+	if c.storeErr != nil {
+		return c.storeErr
+	}
+	return c.cacherMock.Store(ctx, key, val, d...)
+}
+
+func (c *cacherReleaseMock) Release(_ context.Context, key string) error {
+	c.released.Store(key, true)
+	return nil
+}
+
+type cacherTTLMock struct {
+	cacherMock
+	ttl sync.Map
+}
+
+func (c *cacherTTLMock) Store(ctx context.Context, key string, val *Query[any], d ...time.Duration) error {
+	if len(d) > 0 {
+		c.ttl.Store(key, d[0])
+	}
+	return c.cacherMock.Store(ctx, key, val, d...)
+}
+
+// cacherBytesMock keeps serialized entries, the way real backends do
+type cacherBytesMock struct {
+	store sync.Map
+}
+
+func (c *cacherBytesMock) Get(_ context.Context, key string, q *Query[any]) (*Query[any], error) {
+	val, ok := c.store.Load(key)
+	if !ok {
+		return nil, nil
+	}
+
+	if err := q.Unmarshal(val.([]byte)); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func (c *cacherBytesMock) Store(_ context.Context, key string, val *Query[any], _ ...time.Duration) error {
+	bytes, err := val.Marshal()
+	if err != nil {
+		return err
+	}
+
+	c.store.Store(key, bytes)
+	return nil
+}
+
+func (c *cacherBytesMock) Invalidate(context.Context) error {
+	c.store.Range(func(key, _ any) bool {
+		c.store.Delete(key)
+		return true
+	})
+	return nil
+}
+
+func (c *cacherBytesMock) Delete(_ context.Context, key string) error {
+	c.store.Delete(key)
+	return nil
+}
+
+// cacherTagMock is a TagInvalidator
+type cacherTagMock struct {
+	cacherBytesMock
+	tags          sync.Map
+	invalidations int32
+}
+
+func (c *cacherTagMock) Store(ctx context.Context, key string, val *Query[any], d ...time.Duration) error {
+	c.tags.Store(key, val.Metadata().Tags)
+	return c.cacherBytesMock.Store(ctx, key, val, d...)
+}
+
+func (c *cacherTagMock) Invalidate(ctx context.Context) error {
+	atomic.AddInt32(&c.invalidations, 1)
+	return c.cacherBytesMock.Invalidate(ctx)
+}
+
+func (c *cacherTagMock) InvalidateTags(_ context.Context, tags ...string) error {
+	c.tags.Range(func(key, val any) bool {
+		for _, tag := range val.([]string) {
+			if slices.Contains(tags, tag) {
+				c.store.Delete(key)
+				c.tags.Delete(key)
+			}
+		}
+		return true
+	})
+	return nil
+}
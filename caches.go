@@ -1,6 +1,8 @@
 package cache
 
 import (
+	"context"
+	"errors"
 	"sync"
 	"time"
 
@@ -15,18 +17,69 @@ type Caches struct {
 
 	queue *sync.Map
 
-	tmp *Tmp
+	database     string
+	databaseOnce sync.Once
 }
 
+// Tmp holds the options of a query, set by the Cache, NoNegativeCache and Bind scopes
 type Tmp struct {
 	Dur time.Duration
 	Key string
+	// SkipNegative prevents an empty result of the query from being cached
+	SkipNegative bool
+	// Bindings are the values of the placeholders of Key
+	Bindings map[string]any
 }
 
 type Config struct {
 	Easer  bool
 	Cacher Cacher
 	Pfx    string
+	// KeyHasher bounds the length of the identifiers, which are then
+	// the prefix, the table and the hash of the query, look at SHA256Hasher
+	KeyHasher KeyHasher
+	// KeyBuilder replaces the default identifiers, which are built out of Pfx and KeyHasher
+	KeyBuilder KeyBuilder
+	// Normalizer canonicalizes queries before they are identified, NormalizeWhitespace by default,
+	// NormalizeClauses also ignores the order of conditions and of IN values
+	Normalizer Normalizer
+	// Database identifies the database in the identifiers, so that several ones can share a Cacher,
+	// it defaults to the name of the dialector and the hash of its DSN
+	Database string
+	// TenantFromContext returns the tenant of a query, its identifier is then scoped to the tenant
+	// and INSERT / UPDATE / DELETE queries only invalidate the tenant's entries
+	TenantFromContext func(ctx context.Context) (string, bool)
+	// NegativeTTL is the lifetime of "no rows" entries,
+	// when zero they are kept as long as any other result
+	NegativeTTL time.Duration
+	// Codec serializes the queries handed to the Cacher, JSONCodec by default
+	Codec Codec
+	// CompressAbove enables the compression of the serialized queries larger than it, in bytes
+	CompressAbove int
+	// Compressor is used along with CompressAbove, GzipCompressor by default
+	Compressor Compressor
+	// Keyring enables the encryption of the serialized queries, look at NewKeyring
+	Keyring *Keyring
+}
+
+// codec returns the Codec handed to the Cacher along with the queries
+func (conf *Config) codec() Codec {
+	var codec Codec = JSONCodec{}
+	if conf.Codec != nil {
+		codec = conf.Codec
+	}
+	if conf.CompressAbove > 0 {
+		var compressor Compressor = GzipCompressor{}
+		if conf.Compressor != nil {
+			compressor = conf.Compressor
+		}
+		codec = compressedCodec{Codec: codec, compressor: compressor, above: conf.CompressAbove}
+	}
+	if conf.Keyring != nil {
+		// Compressed first, as encrypted data does not compress
+		codec = encryptedCodec{Codec: codec, keyring: conf.Keyring}
+	}
+	return envelopeCodec{Codec: codec}
 }
 
 func (c *Caches) Name() string {
@@ -44,7 +97,6 @@ func (c *Caches) Initialize(db *gorm.DB) error {
 	if c.Conf.Easer {
 		c.queue = &sync.Map{}
 	}
-	c.tmp = &Tmp{}
 
 	callbacks := make(map[queryType]func(db *gorm.DB), 4)
 	callbacks[uponQuery] = db.Callback().Query().Get("gorm:query")
@@ -74,36 +126,71 @@ func (c *Caches) Initialize(db *gorm.DB) error {
 // query is a decorator around the default "gorm:query" callback
 // it takes care to both ease database load and cache results
 func (c *Caches) query(db *gorm.DB) {
+	if info, ok := explainOf(db); ok {
+		c.explain(db, info)
+		return
+	}
 
 	if c.Conf.Easer == false && c.Conf.Cacher == nil {
 		c.callbacks[uponQuery](db)
 		return
 	}
-	identifier := c.tmp.Key
-	if identifier == "" {
-		identifier = c.buildIdentifier(db)
+	if db.DryRun {
+		// Nothing is queried, such as for the subqueries built by ExplainKey, so there is no result to cache
+		c.callbacks[uponQuery](db)
+		return
+	}
+	tmp := tmpOf(db)
+	identifier, err := c.identifierOf(db, tmp)
+	if err != nil {
+		_ = db.AddError(err)
+		return
 	}
 
 	if c.checkCache(db, identifier) {
 		return
 	}
 	c.ease(db, identifier)
-	if db.Error != nil {
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		c.release(db, identifier)
 		return
 	}
 
-	c.storeInCache(db, identifier, c.tmp.Dur)
+	if db.Statement.RowsAffected == 0 {
+		// Negative entry, First / Take will raise gorm.ErrRecordNotFound on hit
+		if tmp.SkipNegative {
+			c.release(db, identifier)
+			return
+		}
+		if c.Conf.NegativeTTL > 0 {
+			c.storeInCache(db, identifier, c.Conf.NegativeTTL)
+			return
+		}
+	}
 
-	if db.Error != nil {
-		return
+	c.storeInCache(db, identifier, tmp.Dur)
+}
+
+// identifierOf returns the key of the Cache scope, or else the identifier built out of the query
+func (c *Caches) identifierOf(db *gorm.DB, tmp *Tmp) (string, error) {
+	if tmp.Key == "" {
+		return c.buildIdentifier(db)
+	}
+	identifier := tmp.Key
+	if isKeyTemplate(identifier) {
+		var err error
+		if identifier, err = resolveKey(db, identifier, tmp.Bindings); err != nil {
+			return "", err
+		}
 	}
+	return c.tenantScope(db.Statement.Context) + identifier, nil
 }
 
 // getMutatorCb returns a decorator which calls the Cacher's Invalidate method
 func (c *Caches) getMutatorCb(typ queryType) func(db *gorm.DB) {
 	return func(db *gorm.DB) {
 		if c.Conf.Cacher != nil {
-			if err := c.Conf.Cacher.Invalidate(db.Statement.Context); err != nil {
+			if err := c.invalidate(db.Statement.Context); err != nil {
 				_ = db.AddError(err)
 			}
 		}
@@ -155,7 +242,14 @@ func (c *Caches) checkCache(db *gorm.DB, identifier string) bool {
 		res, err := c.Conf.Cacher.Get(db.Statement.Context, identifier, &Query[any]{
 			Dest:         db.Statement.Dest,
 			RowsAffected: db.Statement.RowsAffected,
+			codec:        c.Conf.codec(),
+			key:          identifier,
 		})
+		if errors.Is(err, ErrInvalidEntry) {
+			// Handled as a miss, it will be replaced
+			c.deleteEntry(db, identifier)
+			return false
+		}
 		if err != nil {
 			_ = db.AddError(err)
 		}
@@ -170,14 +264,43 @@ func (c *Caches) checkCache(db *gorm.DB, identifier string) bool {
 
 func (c *Caches) storeInCache(db *gorm.DB, identifier string, d ...time.Duration) {
 	if c.Conf.Cacher != nil {
+		meta := Metadata{
+			Version:   EnvelopeVersion,
+			CreatedAt: time.Now(),
+			Tables:    tablesOf(db),
+			Tags:      c.tagsOf(db.Statement.Context),
+		}
+		if len(d) > 0 {
+			meta.TTL = d[0]
+		}
 		err := c.Conf.Cacher.Store(db.Statement.Context, identifier, &Query[any]{
 			Dest:         db.Statement.Dest,
 			RowsAffected: db.Statement.RowsAffected,
+			codec:        c.Conf.codec(),
+			meta:         meta,
+			key:          identifier,
 		}, d...)
 		if err != nil {
 			_ = db.AddError(err)
+			c.release(db, identifier)
+		}
+	}
+}
+
+// release tells a Releaser that the entry of identifier will not be stored
+func (c *Caches) release(db *gorm.DB, identifier string) {
+	if releaser, ok := c.Conf.Cacher.(Releaser); ok {
+		if err := releaser.Release(db.Statement.Context, identifier); err != nil {
+			_ = db.AddError(err)
+		}
+	}
+}
+
+func (c *Caches) deleteEntry(db *gorm.DB, identifier string) {
+	if deleter, ok := c.Conf.Cacher.(Deleter); ok {
+		if err := deleter.Delete(db.Statement.Context, identifier); err != nil {
+			_ = db.AddError(err)
 		}
-		c.tmp = &Tmp{}
 	}
 }
 
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 	"sync"
@@ -516,3 +517,191 @@ func TestCaches_getMutatorCb(t *testing.T) {
 		})
 	}
 }
+
+func TestCaches_negativeCache(t *testing.T) {
+	newCaches := func(conf *Config, incr *int32) *Caches {
+		return &Caches{
+			Conf: conf,
+			callbacks: map[queryType]func(db *gorm.DB){
+				uponQuery: func(db *gorm.DB) {
+					atomic.AddInt32(incr, 1)
+					db.Statement.RowsAffected = 0
+					if db.Statement.RaiseErrorOnNotFound {
+						_ = db.AddError(gorm.ErrRecordNotFound)
+					}
+				},
+			},
+		}
+	}
+	newDB := func(raise bool) *gorm.DB {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = &mockDest{}
+		db.Statement.RaiseErrorOnNotFound = raise
+		db.Statement.SQL.WriteString("demo-query")
+		return db
+	}
+
+	t.Run("stored with negative ttl", func(t *testing.T) {
+		var incr int32
+		cacher := &cacherTTLMock{}
+		caches := newCaches(&Config{Cacher: cacher, NegativeTTL: time.Second}, &incr)
+		db := newDB(false)
+		setTmp(db, &Tmp{Dur: time.Hour})
+		caches.query(db)
+		if db.Error != nil {
+			t.Fatalf("an unexpected error has occurred, %v", db.Error)
+		}
+
+		identifier, _ := caches.buildIdentifier(db)
+		ttl, ok := cacher.ttl.Load(identifier)
+		if !ok {
+			t.Fatal("an empty result was expected to be stored")
+		}
+		if ttl.(time.Duration) != time.Second {
+			t.Errorf("an empty result was expected to be stored for %s, got %s", time.Second, ttl)
+		}
+	})
+
+	t.Run("record not found on hit", func(t *testing.T) {
+		var incr int32
+		caches := newCaches(&Config{Cacher: &cacherMock{}, NegativeTTL: time.Second}, &incr)
+
+		db1 := newDB(true)
+		caches.query(db1)
+		if !errors.Is(db1.Error, gorm.ErrRecordNotFound) {
+			t.Fatalf("expected gorm.ErrRecordNotFound, got %v", db1.Error)
+		}
+
+		db2 := newDB(true)
+		caches.query(db2)
+		if !errors.Is(db2.Error, gorm.ErrRecordNotFound) {
+			t.Errorf("expected gorm.ErrRecordNotFound on a cache hit, got %v", db2.Error)
+		}
+
+		db3 := newDB(false)
+		caches.query(db3)
+		if db3.Error != nil {
+			t.Errorf("an unexpected error has occurred, %v", db3.Error)
+		}
+
+		if act := atomic.LoadInt32(&incr); act != 1 {
+			t.Errorf("expected the query to run %d time, but %d", 1, act)
+		}
+	})
+
+	t.Run("disabled per query", func(t *testing.T) {
+		var incr int32
+		caches := newCaches(&Config{Cacher: &cacherMock{}, NegativeTTL: time.Second}, &incr)
+
+		for i := 0; i < 2; i++ {
+			db := newDB(false)
+			setTmp(db, &Tmp{SkipNegative: true})
+			caches.query(db)
+		}
+
+		if act := atomic.LoadInt32(&incr); act != 2 {
+			t.Errorf("expected the query to run %d times, but %d", 2, act)
+		}
+	})
+}
+
+func TestCaches_release(t *testing.T) {
+	newDB := func() *gorm.DB {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = &mockDest{}
+		db.Statement.SQL.WriteString("demo-query")
+		return db
+	}
+	cases := map[string]struct {
+		cacher  *cacherReleaseMock
+		tmp     *Tmp
+		queryCb func(db *gorm.DB)
+		release bool
+	}{
+		"stored": {
+			cacher:  &cacherReleaseMock{},
+			queryCb: func(db *gorm.DB) { db.Statement.RowsAffected = 1 },
+		},
+		"query error": {
+			cacher:  &cacherReleaseMock{},
+			queryCb: func(db *gorm.DB) { _ = db.AddError(errors.New("query-error")) },
+			release: true,
+		},
+		"negative entry skipped": {
+			cacher:  &cacherReleaseMock{},
+			tmp:     &Tmp{SkipNegative: true},
+			queryCb: func(db *gorm.DB) { db.Statement.RowsAffected = 0 },
+			release: true,
+		},
+		"store error": {
+			cacher:  &cacherReleaseMock{storeErr: errors.New("store-error")},
+			queryCb: func(db *gorm.DB) { db.Statement.RowsAffected = 1 },
+			release: true,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			caches := &Caches{
+				Conf:      &Config{Cacher: tc.cacher},
+				callbacks: map[queryType]func(db *gorm.DB){uponQuery: tc.queryCb},
+			}
+			db := newDB()
+			if tc.tmp != nil {
+				setTmp(db, tc.tmp)
+			}
+			caches.query(db)
+
+			identifier, _ := caches.buildIdentifier(db)
+			if _, released := tc.cacher.released.Load(identifier); released != tc.release {
+				t.Errorf("expected the key to be released: %t", tc.release)
+			}
+		})
+	}
+}
+
+func TestCaches_scopes(t *testing.T) {
+	cacher := &cacherTTLMock{cacherMock: cacherMock{store: &sync.Map{}}}
+	db := openCachedDB(t, &Config{Cacher: cacher})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			scopes := []func(*gorm.DB) *gorm.DB{Cache("user:{id}", time.Duration(i+1)*time.Second), Bind("id", i)}
+			if err := db.Scopes(scopes...).Find(&[]tests.User{}).Error; err != nil {
+				t.Errorf("an unexpected error has occurred, %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 50; i++ {
+		ttl, ok := cacher.ttl.Load(fmt.Sprintf("user:%d", i))
+		if !ok {
+			t.Errorf("expected `user:%d` to be stored", i)
+			continue
+		}
+		if expected := time.Duration(i+1) * time.Second; ttl != expected {
+			t.Errorf("expected `user:%d` to be stored for %s, got %s", i, expected, ttl)
+		}
+	}
+
+	// Not inherited by the next query
+	if err := db.Find(&[]tests.Pet{}).Error; err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	n := 0
+	cacher.store.Range(func(key, _ any) bool {
+		n++
+		return true
+	})
+	if n != 51 {
+		t.Errorf("expected the query without scope to get its own identifier, got %d entries", n)
+	}
+}
+
+// setTmp sets the options of the scopes on the statement of db, the way InstanceSet does
+func setTmp(db *gorm.DB, tmp *Tmp) {
+	db.Statement.Settings.Store(fmt.Sprintf("%p", db.Statement)+tmpSetting, tmp)
+}
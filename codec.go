@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm/schema"
+)
+
+// Codec serializes the Query handed to a Cacher,
+// implement it to plug in msgpack, protobuf and the likes
+type Codec interface {
+	Marshal(q *Query[any]) ([]byte, error)
+	// Unmarshal should restore into q.Dest when it holds a pointer,
+	// so that the result reaches the destination of the query
+	Unmarshal(data []byte, q *Query[any]) error
+}
+
+func init() {
+	// Types held by interfaces of map destinations
+	gob.Register(time.Time{})
+	gob.Register(map[string]any{})
+	gob.Register([]any{})
+}
+
+// JSONCodec is the default Codec, it keeps the exact Go types of the destination
+// look at encodeValue
+type JSONCodec struct{}
+
+// typedQuery is the serialized form of a Query,
+// Typed is false for the plain encoding/json format of former versions
+type typedQuery struct {
+	Dest         json.RawMessage
+	RowsAffected int64
+	Typed        bool
+}
+
+func (JSONCodec) Marshal(q *Query[any]) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encodeValue(&buf, reflect.ValueOf(&q.Dest).Elem()); err != nil {
+		return nil, err
+	}
+	return json.Marshal(typedQuery{
+		Dest:         buf.Bytes(),
+		RowsAffected: q.RowsAffected,
+		Typed:        true,
+	})
+}
+
+func (JSONCodec) Unmarshal(data []byte, q *Query[any]) error {
+	var tq typedQuery
+	if err := json.Unmarshal(data, &tq); err != nil {
+		return err
+	}
+	if !tq.Typed {
+		return json.Unmarshal(data, q)
+	}
+
+	q.RowsAffected = tq.RowsAffected
+	return decodeValue(tq.Dest, reflect.ValueOf(&q.Dest).Elem())
+}
+
+// GobCodec is a compact binary Codec, it needs q.Dest to hold a pointer or a map on Unmarshal.
+// Named locations of time.Time values are not kept
+type GobCodec struct{}
+
+func (GobCodec) Marshal(q *Query[any]) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(q.RowsAffected); err != nil {
+		return nil, err
+	}
+	if err := enc.Encode(q.Dest); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, q *Query[any]) error {
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(&q.RowsAffected); err != nil {
+		return err
+	}
+
+	dest := reflect.ValueOf(q.Dest)
+	switch {
+	case dest.Kind() == reflect.Ptr && !dest.IsNil():
+		// Decoded into a zero value, as gob does not send the zero fields
+		// which would be left untouched in the destination otherwise
+		v := reflect.New(dest.Type().Elem())
+		if err := dec.Decode(v.Interface()); err != nil {
+			return err
+		}
+		dest.Elem().Set(v.Elem())
+		return nil
+	case dest.Kind() == reflect.Map && !dest.IsNil():
+		m := reflect.New(dest.Type())
+		if err := dec.Decode(m.Interface()); err != nil {
+			return err
+		}
+		for _, key := range m.Elem().MapKeys() {
+			dest.SetMapIndex(key, m.Elem().MapIndex(key))
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: gob can not decode into %T", schema.ErrUnsupportedDataType, q.Dest)
+	}
+}
@@ -0,0 +1,153 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+type codecCountMock struct {
+	GobCodec
+	marshals, unmarshals int32
+}
+
+func (c *codecCountMock) Marshal(q *Query[any]) ([]byte, error) {
+	atomic.AddInt32(&c.marshals, 1)
+	return c.GobCodec.Marshal(q)
+}
+
+func (c *codecCountMock) Unmarshal(data []byte, q *Query[any]) error {
+	atomic.AddInt32(&c.unmarshals, 1)
+	return c.GobCodec.Unmarshal(data, q)
+}
+
+func TestGobCodec(t *testing.T) {
+	fixtures := typedFixtures(t)
+	fixtures["struct"] = &[]typedUser{{Name: "ktsivkov", Age: 1 << 60, Avatar: []byte{1}, Extra: map[string]any{"level": int64(3)}}}
+	// Named locations of time.Time values are not kept
+	delete(fixtures, "slice")
+	delete(fixtures, "scalar time")
+	delete(fixtures, "fixed zone")
+	// Zero values are not sent
+	delete(fixtures, "empty slice")
+	delete(fixtures, "empty struct")
+	delete(fixtures, "nil slice")
+	for name, expected := range fixtures {
+		t.Run(name, func(t *testing.T) {
+			bytes, err := (&Query[any]{Dest: expected, RowsAffected: 2, codec: GobCodec{}}).Marshal()
+			if err != nil {
+				t.Fatalf("Marshal resulted to an unexpected error. %v", err)
+			}
+
+			actual := &Query[any]{Dest: reflect.New(reflect.TypeOf(expected).Elem()).Interface(), codec: GobCodec{}}
+			if err := actual.Unmarshal(bytes); err != nil {
+				t.Fatalf("Unmarshal resulted to an unexpected error. %v", err)
+			}
+
+			if actual.RowsAffected != 2 {
+				t.Errorf("Unmarshal was expected to restore the affected rows, got %d", actual.RowsAffected)
+			}
+			if !reflect.DeepEqual(expected, actual.Dest) {
+				t.Errorf("expected %#v, got %#v", expected, actual.Dest)
+			}
+		})
+	}
+
+	t.Run("zero fields", func(t *testing.T) {
+		bytes, err := (&Query[any]{Dest: &typedUser{Name: "ktsivkov"}, codec: GobCodec{}}).Marshal()
+		if err != nil {
+			t.Fatalf("Marshal resulted to an unexpected error. %v", err)
+		}
+		dest := &typedUser{Name: "stale", Age: 5, Tags: []string{"stale"}}
+		if err := (&Query[any]{Dest: dest, codec: GobCodec{}}).Unmarshal(bytes); err != nil {
+			t.Fatalf("Unmarshal resulted to an unexpected error. %v", err)
+		}
+		if !reflect.DeepEqual(dest, &typedUser{Name: "ktsivkov"}) {
+			t.Errorf("expected the zero fields to be reset, got %#v", dest)
+		}
+	})
+
+	t.Run("without destination", func(t *testing.T) {
+		bytes, err := (&Query[any]{Dest: &mockDest{Result: "test"}, codec: GobCodec{}}).Marshal()
+		if err != nil {
+			t.Fatalf("Marshal resulted to an unexpected error. %v", err)
+		}
+		if err := (&Query[any]{codec: GobCodec{}}).Unmarshal(bytes); err == nil {
+			t.Error("an error was expected, got none")
+		}
+	})
+}
+
+func TestQuery_codec(t *testing.T) {
+	t.Run("gob is smaller", func(t *testing.T) {
+		users := make([]typedUser, 100)
+		for i := range users {
+			users[i] = typedUser{Name: "ktsivkov", Age: int64(i)}
+		}
+		jsonBytes, err := (&Query[any]{Dest: &users}).Marshal()
+		if err != nil {
+			t.Fatalf("Marshal resulted to an unexpected error. %v", err)
+		}
+		gobBytes, err := (&Query[any]{Dest: &users, codec: GobCodec{}}).Marshal()
+		if err != nil {
+			t.Fatalf("Marshal resulted to an unexpected error. %v", err)
+		}
+		if len(gobBytes) >= len(jsonBytes) {
+			t.Errorf("gob was expected to be smaller than json, got %d and %d bytes", len(gobBytes), len(jsonBytes))
+		}
+	})
+
+	t.Run("generic query", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(int64(3)); err != nil {
+			t.Fatal(err)
+		}
+		if err := gob.NewEncoder(&buf).Encode(&mockDest{Result: "test"}); err != nil {
+			t.Fatal(err)
+		}
+
+		q := Query[mockDest]{codec: GobCodec{}}
+		if err := q.Unmarshal(buf.Bytes()); err != nil {
+			t.Fatalf("Unmarshal resulted to an unexpected error. %v", err)
+		}
+		if q.Dest.Result != "test" || q.RowsAffected != 3 {
+			t.Errorf("Unmarshal was expected to restore the query, got %+v", q)
+		}
+	})
+
+	t.Run("shared with the cacher", func(t *testing.T) {
+		codec := &codecCountMock{}
+		caches := &Caches{
+			Conf: &Config{Cacher: &cacherBytesMock{}, Codec: codec},
+			callbacks: map[queryType]func(db *gorm.DB){
+				uponQuery: func(db *gorm.DB) {
+					db.Statement.Dest.(*mockDest).Result = db.Statement.SQL.String()
+					db.Statement.RowsAffected = 1
+				},
+			},
+		}
+
+		for i := 0; i < 2; i++ {
+			db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+			db.Statement.Dest = &mockDest{}
+			db.Statement.SQL.WriteString("demo-query")
+			caches.query(db)
+
+			if db.Error != nil {
+				t.Fatalf("an unexpected error has occurred, %v", db.Error)
+			}
+			if res := db.Statement.Dest.(*mockDest); res.Result != "demo-query" {
+				t.Errorf("the execution of the Query expected a result of `%s`, got `%s`", "demo-query", res.Result)
+			}
+		}
+
+		if codec.marshals != 1 || codec.unmarshals != 1 {
+			t.Errorf("the cacher was expected to use the configured codec, got %d marshals and %d unmarshals", codec.marshals, codec.unmarshals)
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// Compressor compresses the serialized queries larger than Config.CompressAbove
+type Compressor interface {
+	// ID is written in the header byte of the compressed entries,
+	// 0 is reserved for uncompressed ones and 1 to 15 for the built-in compressors
+	ID() byte
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+const uncompressed byte = 0
+
+// GzipCompressor is the default Compressor, Level defaults to gzip.DefaultCompression
+type GzipCompressor struct {
+	Level int
+}
+
+func (GzipCompressor) ID() byte {
+	return 1
+}
+
+func (c GzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level(c.Level))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// FlateCompressor is a raw DEFLATE Compressor, Level defaults to flate.DefaultCompression
+type FlateCompressor struct {
+	Level int
+}
+
+func (FlateCompressor) ID() byte {
+	return 2
+}
+
+func (c FlateCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, level(c.Level))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (FlateCompressor) Decompress(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// level maps the zero value of the compressors' Level to the default compression
+func level(l int) int {
+	if l == 0 {
+		return flate.DefaultCompression
+	}
+	return l
+}
+
+// compressedCodec prefixes the output of a Codec with a header byte,
+// naming the Compressor that was used or 0 when it is stored as is
+type compressedCodec struct {
+	Codec
+	compressor Compressor
+	above      int
+}
+
+func (c compressedCodec) Marshal(q *Query[any]) ([]byte, error) {
+	data, err := c.Codec.Marshal(q)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) <= c.above {
+		return append([]byte{uncompressed}, data...), nil
+	}
+
+	compressed, err := c.compressor.Compress(data)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{c.compressor.ID()}, compressed...), nil
+}
+
+func (c compressedCodec) Unmarshal(data []byte, q *Query[any]) error {
+	if len(data) == 0 {
+		return fmt.Errorf("%w: empty entry", ErrInvalidEntry)
+	}
+
+	var compressor Compressor
+	switch id := data[0]; id {
+	case uncompressed:
+		return c.Codec.Unmarshal(data[1:], q)
+	case c.compressor.ID():
+		compressor = c.compressor
+	case GzipCompressor{}.ID():
+		compressor = GzipCompressor{}
+	case FlateCompressor{}.ID():
+		compressor = FlateCompressor{}
+	default:
+		return fmt.Errorf("%w: unknown compressor %d", ErrInvalidEntry, id)
+	}
+
+	decompressed, err := compressor.Decompress(data[1:])
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEntry, err)
+	}
+	return c.Codec.Unmarshal(decompressed, q)
+}
@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+type compressorMock struct{}
+
+func (compressorMock) ID() byte {
+	return 16
+}
+
+func (compressorMock) Compress(data []byte) ([]byte, error) {
+	return reversed(data), nil
+}
+
+func (compressorMock) Decompress(data []byte) ([]byte, error) {
+	return reversed(data), nil
+}
+
+func reversed(data []byte) []byte {
+	res := bytes.Clone(data)
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+	return res
+}
+
+func TestCompressedCodec(t *testing.T) {
+	small := &mockDest{Result: "small"}
+	large := &mockDest{Result: strings.Repeat("large", 1000)}
+
+	for name, compressor := range map[string]Compressor{
+		"gzip":   GzipCompressor{},
+		"flate":  FlateCompressor{Level: 9},
+		"custom": compressorMock{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			codec := compressedCodec{Codec: JSONCodec{}, compressor: compressor, above: 100}
+
+			smallBytes, err := codec.Marshal(&Query[any]{Dest: small})
+			if err != nil {
+				t.Fatalf("Marshal resulted to an unexpected error. %v", err)
+			}
+			if smallBytes[0] != uncompressed {
+				t.Errorf("an entry below the threshold was expected to be stored uncompressed, got header %d", smallBytes[0])
+			}
+
+			largeBytes, err := codec.Marshal(&Query[any]{Dest: large})
+			if err != nil {
+				t.Fatalf("Marshal resulted to an unexpected error. %v", err)
+			}
+			if largeBytes[0] != compressor.ID() {
+				t.Errorf("an entry above the threshold was expected to be compressed, got header %d", largeBytes[0])
+			}
+			if name != "custom" && len(largeBytes) >= len(large.Result) {
+				t.Errorf("a compressed entry was expected to be smaller than %d bytes, got %d", len(large.Result), len(largeBytes))
+			}
+
+			for _, expected := range []*mockDest{small, large} {
+				data := smallBytes
+				if expected == large {
+					data = largeBytes
+				}
+				actual := &mockDest{}
+				if err := codec.Unmarshal(data, &Query[any]{Dest: actual}); err != nil {
+					t.Fatalf("Unmarshal resulted to an unexpected error. %v", err)
+				}
+				if actual.Result != expected.Result {
+					t.Errorf("Unmarshal was expected to restore the entry, got %.20s", actual.Result)
+				}
+			}
+		})
+	}
+
+	t.Run("built-in compressors are always readable", func(t *testing.T) {
+		data, err := compressedCodec{Codec: JSONCodec{}, compressor: FlateCompressor{}}.Marshal(&Query[any]{Dest: large})
+		if err != nil {
+			t.Fatalf("Marshal resulted to an unexpected error. %v", err)
+		}
+
+		actual := &mockDest{}
+		codec := compressedCodec{Codec: JSONCodec{}, compressor: GzipCompressor{}}
+		if err := codec.Unmarshal(data, &Query[any]{Dest: actual}); err != nil {
+			t.Fatalf("Unmarshal resulted to an unexpected error. %v", err)
+		}
+		if actual.Result != large.Result {
+			t.Errorf("Unmarshal was expected to restore the entry, got %.20s", actual.Result)
+		}
+	})
+
+	t.Run("unknown compressor", func(t *testing.T) {
+		codec := compressedCodec{Codec: JSONCodec{}, compressor: GzipCompressor{}}
+		if err := codec.Unmarshal([]byte{42, '{', '}'}, &Query[any]{Dest: &mockDest{}}); err == nil {
+			t.Error("an error was expected, got none")
+		}
+	})
+}
+
+func TestCaches_compression(t *testing.T) {
+	cacher := &cacherBytesMock{}
+	caches := &Caches{
+		Conf: &Config{Cacher: cacher, CompressAbove: 100},
+		callbacks: map[queryType]func(db *gorm.DB){
+			uponQuery: func(db *gorm.DB) {
+				db.Statement.Dest.(*mockDest).Result = strings.Repeat(db.Statement.SQL.String(), 100)
+				db.Statement.RowsAffected = 1
+			},
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = &mockDest{}
+		db.Statement.SQL.WriteString("demo-query")
+		caches.query(db)
+
+		if db.Error != nil {
+			t.Fatalf("an unexpected error has occurred, %v", db.Error)
+		}
+		if res := db.Statement.Dest.(*mockDest); res.Result != strings.Repeat("demo-query", 100) {
+			t.Errorf("the execution of the Query expected a result of `%.20s...`, got `%.20s...`", "demo-query", res.Result)
+		}
+	}
+
+	cacher.store.Range(func(_, val any) bool {
+		if len(val.([]byte)) >= len("demo-query")*100 {
+			t.Errorf("the entry was expected to be compressed, got %d bytes", len(val.([]byte)))
+		}
+		return true
+	})
+}
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+)
+
+// Keyring holds the AES keys used to encrypt the cached entries,
+// each of them is identified by an ID which is written in the header of the entries,
+// so that keys can be rotated while entries encrypted with former ones are still readable
+type Keyring struct {
+	current byte
+	aeads   map[byte]cipher.AEAD
+}
+
+// NewKeyring returns a Keyring encrypting with the key identified by current,
+// and decrypting with any of keys, which must be 16, 24 or 32 bytes long
+func NewKeyring(current byte, keys map[byte][]byte) (*Keyring, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("cache: no key with id %d", current)
+	}
+
+	kr := &Keyring{
+		current: current,
+		aeads:   make(map[byte]cipher.AEAD, len(keys)),
+	}
+	for id, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		kr.aeads[id] = aead
+	}
+	return kr, nil
+}
+
+// encryptedCodec seals the output of a Codec with AES-GCM,
+// the entries are made of the key ID, the nonce and the sealed data.
+// The key ID and the identifier of the entry are authenticated along with it,
+// so that an entry can not be moved under another identifier, of another tenant for instance
+type encryptedCodec struct {
+	Codec
+	keyring *Keyring
+}
+
+func (c encryptedCodec) Marshal(q *Query[any]) ([]byte, error) {
+	data, err := c.Codec.Marshal(q)
+	if err != nil {
+		return nil, err
+	}
+
+	id := c.keyring.current
+	aead := c.keyring.aeads[id]
+	header := make([]byte, 1+aead.NonceSize(), 1+aead.NonceSize()+len(data)+aead.Overhead())
+	header[0] = id
+	if _, err := rand.Read(header[1:]); err != nil {
+		return nil, err
+	}
+	return aead.Seal(header, header[1:], data, additionalData(id, q.key)), nil
+}
+
+func (c encryptedCodec) Unmarshal(data []byte, q *Query[any]) error {
+	if len(data) == 0 {
+		return fmt.Errorf("%w: empty entry", ErrInvalidEntry)
+	}
+
+	aead, ok := c.keyring.aeads[data[0]]
+	if !ok {
+		return fmt.Errorf("%w: no key with id %d", ErrInvalidEntry, data[0])
+	}
+	if len(data) < 1+aead.NonceSize() {
+		return fmt.Errorf("%w: truncated entry", ErrInvalidEntry)
+	}
+
+	nonce, sealed := data[1:1+aead.NonceSize()], data[1+aead.NonceSize():]
+	opened, err := aead.Open(nil, nonce, sealed, additionalData(data[0], q.key))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEntry, err)
+	}
+	return c.Codec.Unmarshal(opened, q)
+}
+
+// additionalData is authenticated along with an entry, it is the key ID followed by the identifier
+func additionalData(id byte, key string) []byte {
+	return append([]byte{id}, key...)
+}
@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestEncryptedCodec(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 16)
+	secret := &mockDest{Result: "4111-1111-1111-1111"}
+
+	oldKeyring, err := NewKeyring(1, map[byte][]byte{1: oldKey})
+	if err != nil {
+		t.Fatalf("NewKeyring resulted to an unexpected error. %v", err)
+	}
+	rotatedKeyring, err := NewKeyring(2, map[byte][]byte{1: oldKey, 2: newKey})
+	if err != nil {
+		t.Fatalf("NewKeyring resulted to an unexpected error. %v", err)
+	}
+
+	oldCodec := encryptedCodec{Codec: JSONCodec{}, keyring: oldKeyring}
+	rotatedCodec := encryptedCodec{Codec: JSONCodec{}, keyring: rotatedKeyring}
+
+	oldData, err := oldCodec.Marshal(&Query[any]{Dest: secret})
+	if err != nil {
+		t.Fatalf("Marshal resulted to an unexpected error. %v", err)
+	}
+	if bytes.Contains(oldData, []byte(secret.Result)) {
+		t.Error("an encrypted entry was expected not to contain the plaintext")
+	}
+
+	t.Run("key rotation", func(t *testing.T) {
+		newData, err := rotatedCodec.Marshal(&Query[any]{Dest: secret})
+		if err != nil {
+			t.Fatalf("Marshal resulted to an unexpected error. %v", err)
+		}
+		if newData[0] != 2 {
+			t.Errorf("an entry was expected to be encrypted with the current key, got key %d", newData[0])
+		}
+
+		for _, data := range [][]byte{oldData, newData} {
+			actual := &mockDest{}
+			if err := rotatedCodec.Unmarshal(data, &Query[any]{Dest: actual}); err != nil {
+				t.Fatalf("Unmarshal resulted to an unexpected error. %v", err)
+			}
+			if actual.Result != secret.Result {
+				t.Errorf("Unmarshal was expected to restore the entry, got %s", actual.Result)
+			}
+		}
+
+		if err := oldCodec.Unmarshal(newData, &Query[any]{Dest: &mockDest{}}); err == nil {
+			t.Error("an error was expected for an unknown key, got none")
+		}
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		data := bytes.Clone(oldData)
+		data[len(data)-1] ^= 1
+		if err := oldCodec.Unmarshal(data, &Query[any]{Dest: &mockDest{}}); err == nil {
+			t.Error("an error was expected, got none")
+		}
+		if err := oldCodec.Unmarshal(oldData[:5], &Query[any]{Dest: &mockDest{}}); err == nil {
+			t.Error("an error was expected, got none")
+		}
+	})
+
+	t.Run("moved to another key", func(t *testing.T) {
+		data, err := oldCodec.Marshal(&Query[any]{Dest: secret, key: "tenant:a:user:1"})
+		if err != nil {
+			t.Fatalf("Marshal resulted to an unexpected error. %v", err)
+		}
+		if err := oldCodec.Unmarshal(data, &Query[any]{Dest: &mockDest{}, key: "tenant:a:user:1"}); err != nil {
+			t.Errorf("Unmarshal resulted to an unexpected error. %v", err)
+		}
+		err = oldCodec.Unmarshal(data, &Query[any]{Dest: &mockDest{}, key: "tenant:b:user:1"})
+		if !errors.Is(err, ErrInvalidEntry) {
+			t.Errorf("expected ErrInvalidEntry for an entry moved to another key, got %v", err)
+		}
+	})
+
+	t.Run("invalid keyring", func(t *testing.T) {
+		if _, err := NewKeyring(1, map[byte][]byte{1: []byte("short")}); err == nil {
+			t.Error("an error was expected for an invalid key size, got none")
+		}
+		if _, err := NewKeyring(2, map[byte][]byte{1: oldKey}); err == nil {
+			t.Error("an error was expected for a missing current key, got none")
+		}
+	})
+}
+
+func TestCaches_encryption(t *testing.T) {
+	keyring, err := NewKeyring(1, map[byte][]byte{1: bytes.Repeat([]byte{1}, 32)})
+	if err != nil {
+		t.Fatalf("NewKeyring resulted to an unexpected error. %v", err)
+	}
+
+	cacher := &cacherBytesMock{}
+	caches := &Caches{
+		Conf: &Config{Cacher: cacher, Keyring: keyring, CompressAbove: 1},
+		callbacks: map[queryType]func(db *gorm.DB){
+			uponQuery: func(db *gorm.DB) {
+				db.Statement.Dest.(*mockDest).Result = "secret-" + db.Statement.SQL.String()
+				db.Statement.RowsAffected = 1
+			},
+		},
+	}
+
+	for i := 0; i < 2; i++ {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = &mockDest{}
+		db.Statement.SQL.WriteString("demo-query")
+		caches.query(db)
+
+		if db.Error != nil {
+			t.Fatalf("an unexpected error has occurred, %v", db.Error)
+		}
+		if res := db.Statement.Dest.(*mockDest); res.Result != "secret-demo-query" {
+			t.Errorf("the execution of the Query expected a result of `%s`, got `%s`", "secret-demo-query", res.Result)
+		}
+	}
+
+	cacher.store.Range(func(_, val any) bool {
+		if bytes.Contains(val.([]byte), []byte("secret")) {
+			t.Error("the entry was expected to be stored encrypted")
+		}
+		return true
+	})
+}
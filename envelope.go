@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"reflect"
+	"time"
+)
+
+// ErrInvalidEntry is returned when decoding corrupted or incompatible entries,
+// the Caches handle them as misses and delete them from a Deleter
+var ErrInvalidEntry = errors.New("cache: invalid entry")
+
+// EnvelopeVersion is the version of the format written by the envelope,
+// version 1 had no tags and version 2 no layout
+const EnvelopeVersion = 3
+
+// envelopeMagic starts every envelope, it can not be mistaken for
+// the first byte of the former format, which was the Codec's output
+var envelopeMagic = []byte{0xff, 'g', 'c'}
+
+// Metadata describes a cached query, it is carried by the envelope
+type Metadata struct {
+	// Version is the envelope format version, 0 for the entries stored before it
+	Version   int
+	CreatedAt time.Time
+	TTL       time.Duration
+	// Tables are the tables the query was seen to read from
+	Tables []string
+	// Tags are the ones a TagInvalidator invalidates the entry by
+	Tags []string
+}
+
+// envelopeCodec wraps the output of a Codec with its Metadata, the layout is:
+//
+//	magic | version | created at | ttl | tables | tags | layout | payload | crc32
+//
+// with the tables and the tags being a count followed by as many length prefixed strings,
+// the layout naming the Codecs of the payload, so that the entries of another Config are told apart,
+//
+// the integers unsigned varints but for the version byte, and the checksum covering everything before it
+type envelopeCodec struct {
+	Codec
+}
+
+func (c envelopeCodec) Marshal(q *Query[any]) ([]byte, error) {
+	payload, err := c.Codec.Marshal(q)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(payload)+64))
+	buf.Write(envelopeMagic)
+	buf.WriteByte(EnvelopeVersion)
+	buf.Write(binary.AppendUvarint(nil, uint64(q.meta.CreatedAt.UnixNano())))
+	buf.Write(binary.AppendUvarint(nil, uint64(q.meta.TTL)))
+	writeStrings(buf, q.meta.Tables)
+	writeStrings(buf, q.meta.Tags)
+	writeStrings(buf, []string{layoutOf(c.Codec)})
+	buf.Write(payload)
+	return binary.BigEndian.AppendUint32(buf.Bytes(), crc32.ChecksumIEEE(buf.Bytes())), nil
+}
+
+func (c envelopeCodec) Unmarshal(data []byte, q *Query[any]) error {
+	if !bytes.HasPrefix(data, envelopeMagic) {
+		// Stored before the envelope, during a rolling upgrade
+		q.meta = Metadata{}
+		return c.Codec.Unmarshal(data, q)
+	}
+	if len(data) < len(envelopeMagic)+1+crc32.Size {
+		return fmt.Errorf("%w: truncated envelope", ErrInvalidEntry)
+	}
+
+	body, sum := data[:len(data)-crc32.Size], data[len(data)-crc32.Size:]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(sum) {
+		return fmt.Errorf("%w: checksum mismatch", ErrInvalidEntry)
+	}
+	version := body[len(envelopeMagic)]
+	if version < 1 || version > EnvelopeVersion {
+		return fmt.Errorf("%w: unsupported envelope version %d", ErrInvalidEntry, version)
+	}
+
+	r := bytes.NewReader(body[len(envelopeMagic)+1:])
+	created, err := binary.ReadUvarint(r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEntry, err)
+	}
+	ttl, err := binary.ReadUvarint(r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEntry, err)
+	}
+	tables, err := readStrings(r)
+	if err != nil {
+		return err
+	}
+	var tags []string
+	if version >= 2 {
+		if tags, err = readStrings(r); err != nil {
+			return err
+		}
+	}
+	if version >= 3 {
+		layout, err := readStrings(r)
+		if err != nil || len(layout) != 1 {
+			return fmt.Errorf("%w: invalid layout", ErrInvalidEntry)
+		}
+		if expected := layoutOf(c.Codec); layout[0] != expected {
+			return fmt.Errorf("%w: stored as %s, read as %s", ErrInvalidEntry, layout[0], expected)
+		}
+	}
+
+	q.meta = Metadata{
+		Version:   int(version),
+		CreatedAt: time.Unix(0, int64(created)),
+		TTL:       time.Duration(ttl),
+		Tables:    tables,
+		Tags:      tags,
+	}
+	return c.Codec.Unmarshal(body[len(body)-r.Len():], q)
+}
+
+// layoutOf names the Codecs wrapped by codec, such as "encrypted>compressed>json"
+func layoutOf(codec Codec) string {
+	switch c := codec.(type) {
+	case encryptedCodec:
+		return "encrypted>" + layoutOf(c.Codec)
+	case compressedCodec:
+		return "compressed>" + layoutOf(c.Codec)
+	case JSONCodec:
+		return "json"
+	case GobCodec:
+		return "gob"
+	}
+	return typeName(reflect.TypeOf(codec))
+}
+
+func writeStrings(buf *bytes.Buffer, values []string) {
+	buf.Write(binary.AppendUvarint(nil, uint64(len(values))))
+	for _, value := range values {
+		buf.Write(binary.AppendUvarint(nil, uint64(len(value))))
+		buf.WriteString(value)
+	}
+}
+
+func readStrings(r *bytes.Reader) ([]string, error) {
+	count, err := binary.ReadUvarint(r)
+	if err != nil || count > uint64(r.Len()) {
+		return nil, fmt.Errorf("%w: invalid strings", ErrInvalidEntry)
+	}
+	values := make([]string, count)
+	for i := range values {
+		size, err := binary.ReadUvarint(r)
+		if err != nil || size > uint64(r.Len()) {
+			return nil, fmt.Errorf("%w: invalid strings", ErrInvalidEntry)
+		}
+		value := make([]byte, size)
+		_, _ = r.Read(value)
+		values[i] = string(value)
+	}
+	return values, nil
+}
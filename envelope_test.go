@@ -0,0 +1,216 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestEnvelopeCodec(t *testing.T) {
+	codec := envelopeCodec{Codec: JSONCodec{}}
+	meta := Metadata{
+		Version:   EnvelopeVersion,
+		CreatedAt: time.Unix(0, time.Now().UnixNano()),
+		TTL:       time.Minute,
+		Tables:    []string{"orders", "users"},
+		Tags:      []string{"tenant:1"},
+	}
+	data, err := codec.Marshal(&Query[any]{Dest: &mockDest{Result: "test"}, RowsAffected: 1, meta: meta})
+	if err != nil {
+		t.Fatalf("Marshal resulted to an unexpected error. %v", err)
+	}
+
+	t.Run("metadata", func(t *testing.T) {
+		dest := &mockDest{}
+		q := &Query[any]{Dest: dest}
+		if err := codec.Unmarshal(data, q); err != nil {
+			t.Fatalf("Unmarshal resulted to an unexpected error. %v", err)
+		}
+		if dest.Result != "test" || q.RowsAffected != 1 {
+			t.Errorf("Unmarshal was expected to restore the query, got %+v", q)
+		}
+		if !reflect.DeepEqual(q.Metadata(), meta) {
+			t.Errorf("Unmarshal was expected to restore the metadata %+v, got %+v", meta, q.Metadata())
+		}
+	})
+
+	t.Run("corrupted", func(t *testing.T) {
+		for i := range data {
+			corrupted := append([]byte(nil), data...)
+			corrupted[i] ^= 0x10
+			err := codec.Unmarshal(corrupted, &Query[any]{Dest: &mockDest{}})
+			if i < len(envelopeMagic) {
+				continue // Read as the former format
+			}
+			if !errors.Is(err, ErrInvalidEntry) {
+				t.Fatalf("expected ErrInvalidEntry when altering byte %d, got %v", i, err)
+			}
+		}
+		if err := codec.Unmarshal(data[:5], &Query[any]{Dest: &mockDest{}}); !errors.Is(err, ErrInvalidEntry) {
+			t.Errorf("expected ErrInvalidEntry for a truncated entry, got %v", err)
+		}
+	})
+
+	t.Run("incompatible", func(t *testing.T) {
+		next := envelopeCodec{Codec: JSONCodec{}}
+		data, err := next.Marshal(&Query[any]{Dest: &mockDest{}, meta: meta})
+		if err != nil {
+			t.Fatalf("Marshal resulted to an unexpected error. %v", err)
+		}
+		data[len(envelopeMagic)] = EnvelopeVersion + 1
+		data = data[:len(data)-4]
+		data = appendChecksum(data)
+
+		if err := codec.Unmarshal(data, &Query[any]{Dest: &mockDest{}}); !errors.Is(err, ErrInvalidEntry) {
+			t.Errorf("expected ErrInvalidEntry, got %v", err)
+		}
+	})
+
+	t.Run("another config", func(t *testing.T) {
+		keyring, err := NewKeyring(1, map[byte][]byte{1: bytes.Repeat([]byte{1}, 32)})
+		if err != nil {
+			t.Fatalf("NewKeyring resulted to an unexpected error. %v", err)
+		}
+		configs := map[string]*Config{
+			"json":       {},
+			"gob":        {Codec: GobCodec{}},
+			"compressed": {CompressAbove: 1},
+			"encrypted":  {Keyring: keyring},
+		}
+		for stored, storedConf := range configs {
+			data, err := storedConf.codec().Marshal(&Query[any]{Dest: &mockDest{Result: "test"}, meta: meta})
+			if err != nil {
+				t.Fatalf("Marshal resulted to an unexpected error. %v", err)
+			}
+			for read, readConf := range configs {
+				if read == stored {
+					continue
+				}
+				err := readConf.codec().Unmarshal(data, &Query[any]{Dest: &mockDest{}})
+				if !errors.Is(err, ErrInvalidEntry) {
+					t.Errorf("expected ErrInvalidEntry for an entry stored %s and read %s, got %v", stored, read, err)
+				}
+			}
+		}
+	})
+
+	t.Run("another destination", func(t *testing.T) {
+		// Not an invalid entry, the query reading it is the wrong one
+		var dest int
+		err := codec.Unmarshal(data, &Query[any]{Dest: &dest})
+		if err == nil || errors.Is(err, ErrInvalidEntry) {
+			t.Errorf("expected the error of the Codec, got %v", err)
+		}
+	})
+
+	t.Run("version 2", func(t *testing.T) {
+		var buf bytes.Buffer
+		buf.Write(envelopeMagic)
+		buf.WriteByte(2)
+		buf.Write(binary.AppendUvarint(nil, uint64(meta.CreatedAt.UnixNano())))
+		buf.Write(binary.AppendUvarint(nil, uint64(meta.TTL)))
+		writeStrings(&buf, meta.Tables)
+		writeStrings(&buf, meta.Tags)
+		buf.WriteString(`{"Dest":{"Result":"v2"},"RowsAffected":1}`)
+
+		dest := &mockDest{}
+		q := &Query[any]{Dest: dest}
+		if err := codec.Unmarshal(appendChecksum(buf.Bytes()), q); err != nil {
+			t.Fatalf("Unmarshal resulted to an unexpected error. %v", err)
+		}
+		if dest.Result != "v2" || q.Metadata().Version != 2 || !reflect.DeepEqual(q.Metadata().Tags, meta.Tags) {
+			t.Errorf("Unmarshal was expected to read version 2, got %+v", q)
+		}
+	})
+
+	t.Run("version 1", func(t *testing.T) {
+		var buf bytes.Buffer
+		buf.Write(envelopeMagic)
+		buf.WriteByte(1)
+		buf.Write(binary.AppendUvarint(nil, uint64(meta.CreatedAt.UnixNano())))
+		buf.Write(binary.AppendUvarint(nil, uint64(meta.TTL)))
+		writeStrings(&buf, meta.Tables)
+		buf.WriteString(`{"Dest":{"Result":"v1"},"RowsAffected":1}`)
+
+		dest := &mockDest{}
+		q := &Query[any]{Dest: dest}
+		if err := codec.Unmarshal(appendChecksum(buf.Bytes()), q); err != nil {
+			t.Fatalf("Unmarshal resulted to an unexpected error. %v", err)
+		}
+		if dest.Result != "v1" || q.Metadata().Version != 1 || q.Metadata().Tags != nil {
+			t.Errorf("Unmarshal was expected to read version 1, got %+v", q)
+		}
+	})
+
+	t.Run("former format", func(t *testing.T) {
+		dest := &mockDest{}
+		q := &Query[any]{Dest: dest}
+		if err := codec.Unmarshal([]byte(`{"Dest":{"Result":"legacy"},"RowsAffected":3}`), q); err != nil {
+			t.Fatalf("Unmarshal resulted to an unexpected error. %v", err)
+		}
+		if dest.Result != "legacy" || q.RowsAffected != 3 || q.Metadata().Version != 0 {
+			t.Errorf("Unmarshal was expected to read the former format, got %+v", q)
+		}
+	})
+}
+
+func TestCaches_invalidEntry(t *testing.T) {
+	var incr int
+	cacher := &cacherBytesMock{}
+	caches := &Caches{
+		Conf: &Config{Cacher: cacher},
+		callbacks: map[queryType]func(db *gorm.DB){
+			uponQuery: func(db *gorm.DB) {
+				incr++
+				db.Statement.Dest.(*mockDest).Result = db.Statement.SQL.String()
+				db.Statement.RowsAffected = 1
+			},
+		},
+	}
+	newDB := func() *gorm.DB {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = &mockDest{}
+		db.Statement.SQL.WriteString("demo-query")
+		return db
+	}
+
+	db := newDB()
+	caches.query(db)
+	identifier, _ := caches.buildIdentifier(db)
+	val, _ := cacher.store.Load(identifier)
+	corrupted := append([]byte(nil), val.([]byte)...)
+	corrupted[len(corrupted)/2] ^= 1
+	cacher.store.Store(identifier, corrupted)
+
+	db = newDB()
+	caches.query(db)
+	if db.Error != nil {
+		t.Fatalf("a corrupted entry was expected to be handled as a miss, got %v", db.Error)
+	}
+	if res := db.Statement.Dest.(*mockDest); res.Result != "demo-query" {
+		t.Errorf("the execution of the Query expected a result of `%s`, got `%s`", "demo-query", res.Result)
+	}
+	if incr != 2 {
+		t.Errorf("expected the query to run %d times, but %d", 2, incr)
+	}
+
+	val, _ = cacher.store.Load(identifier)
+	q := &Query[any]{Dest: &mockDest{}, codec: caches.Conf.codec()}
+	if err := q.Unmarshal(val.([]byte)); err != nil {
+		t.Errorf("the corrupted entry was expected to be replaced, got %v", err)
+	}
+	if q.Metadata().CreatedAt.IsZero() {
+		t.Error("the stored entry was expected to carry its creation time")
+	}
+}
+
+func appendChecksum(data []byte) []byte {
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+}
@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+)
+
+const explainSetting = "gorm-caches:explain"
+
+// KeyInfo tells how the result of a query would be cached, look at Caches.ExplainKey
+type KeyInfo struct {
+	// Key is the identifier of the query
+	Key string
+	// SQL and Vars are the query the identifier was built out of
+	SQL  string
+	Vars []any
+	// Tables are the tables the result depends on
+	Tables []string
+	// TTL is the duration of the Cache scope, zero leaves it to the Cacher
+	TTL time.Duration
+	// NegativeTTL is the lifetime of "no rows" results, zero when they are kept as long as TTL
+	NegativeTTL time.Duration
+	// SkipNegative tells that "no rows" results would not be cached
+	SkipNegative bool
+	// Tags are the tags stored along with the result
+	Tags []string
+	// Skipped tells that the result would not be cached, because of Reason
+	Skipped bool
+	Reason  string
+}
+
+// ExplainKey returns how the result of the query would be cached, without executing it.
+// db is the query before its finisher, with its destination set by Model:
+//
+//	info := caches.ExplainKey(db.Model(&[]User{}).Scopes(cache.Cache("users")).Where("age > ?", 18))
+//
+// Finishers such as First add conditions of their own, which are then not known
+func (c *Caches) ExplainKey(db *gorm.DB) KeyInfo {
+	info := &KeyInfo{}
+	tx := db.Session(&gorm.Session{DryRun: true}).Set(explainSetting, info)
+	tx = tx.Callback().Query().Execute(tx)
+	if tx.Error != nil && !info.Skipped {
+		info.Skipped = true
+		info.Reason = tx.Error.Error()
+	}
+	return *info
+}
+
+func explainOf(db *gorm.DB) (*KeyInfo, bool) {
+	v, ok := db.Get(explainSetting)
+	if !ok {
+		return nil, false
+	}
+	info, ok := v.(*KeyInfo)
+	return info, ok
+}
+
+// explain fills info in place of querying
+func (c *Caches) explain(db *gorm.DB, info *KeyInfo) {
+	tmp := tmpOf(db)
+	if db.Error != nil {
+		info.Skipped = true
+		info.Reason = db.Error.Error()
+		return
+	}
+	if db.Statement.Dest == nil {
+		info.Skipped = true
+		info.Reason = "no destination, it is set by Model"
+		return
+	}
+	callbacks.BuildQuerySQL(db)
+	info.SQL = db.Statement.SQL.String()
+	info.Vars = db.Statement.Vars
+	info.Tables = tablesOf(db)
+	info.TTL = tmp.Dur
+	info.NegativeTTL = c.Conf.NegativeTTL
+	info.SkipNegative = tmp.SkipNegative
+	info.Tags = c.tagsOf(db.Statement.Context)
+
+	if c.Conf.Cacher == nil {
+		info.Skipped = true
+		info.Reason = "no Cacher is configured"
+	}
+	key, err := c.identifierOf(db, tmp)
+	if err != nil {
+		info.Skipped = true
+		info.Reason = err.Error()
+		return
+	}
+	info.Key = key
+}
@@ -0,0 +1,168 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestCaches_ExplainKey(t *testing.T) {
+	open := func(t *testing.T, conf *Config) (*gorm.DB, *Caches) {
+		db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{Logger: logger.Discard})
+		if err != nil {
+			t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+		}
+		if err := db.Callback().Query().Replace("gorm:query", buildQuery); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		caches := &Caches{Conf: conf}
+		if err := db.Use(caches); err != nil {
+			t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+		}
+		return db, caches
+	}
+
+	t.Run("default identifier", func(t *testing.T) {
+		cacher := &cacherMock{}
+		db, caches := open(t, &Config{Cacher: cacher, NegativeTTL: time.Second})
+
+		info := caches.ExplainKey(db.Model(&[]tests.User{}).Scopes(Cache("", time.Minute)).Where("age > ?", 18))
+		if info.Skipped {
+			t.Fatalf("the query was not expected to be skipped, %s", info.Reason)
+		}
+		if expected := "SELECT * FROM `users` WHERE age > ? AND `users`.`deleted_at` IS NULL"; info.SQL != expected {
+			t.Errorf("SQL expected to be `%s`, got `%s`", expected, info.SQL)
+		}
+		if !reflect.DeepEqual(info.Vars, []any{18}) {
+			t.Errorf("Vars expected to be [18], got %v", info.Vars)
+		}
+		if !reflect.DeepEqual(info.Tables, []string{"users"}) {
+			t.Errorf("Tables expected to be [users], got %v", info.Tables)
+		}
+		if info.TTL != time.Minute || info.NegativeTTL != time.Second {
+			t.Errorf("TTL expected to be 1m and 1s, got %s and %s", info.TTL, info.NegativeTTL)
+		}
+
+		var users []tests.User
+		if err := db.Scopes(Cache("", time.Minute)).Where("age > ?", 18).Find(&users).Error; err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if _, ok := cacher.store.Load(info.Key); !ok {
+			t.Errorf("the result was expected to be stored under `%s`", info.Key)
+		}
+	})
+
+	t.Run("nothing executed", func(t *testing.T) {
+		cacher := &cacherMock{}
+		db, caches := open(t, &Config{Cacher: cacher})
+		var executed bool
+		caches.callbacks[uponQuery] = func(*gorm.DB) { executed = true }
+
+		info := caches.ExplainKey(db.Model(&tests.User{}).Scopes(Cache("user:{id}"), NoNegativeCache()).Where("id = ?", 3))
+		if info.Key != "user:3" || !info.SkipNegative {
+			t.Errorf("expected the key `user:3` and negative results to be skipped, got %+v", info)
+		}
+		if executed {
+			t.Error("the query was not expected to be executed")
+		}
+		if cacher.store != nil {
+			t.Error("nothing was expected to be stored")
+		}
+
+		// The options of the Cache scope do not leak into the next query
+		if info := caches.ExplainKey(db.Model(&tests.User{})); info.TTL != 0 || info.SkipNegative || info.Key == "user:3" {
+			t.Errorf("the options of the previous query were not expected to be kept, got %+v", info)
+		}
+	})
+
+	t.Run("subquery", func(t *testing.T) {
+		cacher := &cacherMock{store: &sync.Map{}}
+		db, caches := open(t, &Config{Cacher: cacher})
+
+		subquery := db.Model(&tests.Pet{}).Select("user_id")
+		info := caches.ExplainKey(db.Model(&[]tests.User{}).Where("id IN (?)", subquery))
+		if info.Skipped {
+			t.Fatalf("the query was not expected to be skipped, %s", info.Reason)
+		}
+		n := 0
+		cacher.store.Range(func(_, _ any) bool {
+			n++
+			return true
+		})
+		if n != 0 {
+			t.Errorf("the subquery was not expected to be stored, got %d entries", n)
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		cacher := &cacherMock{store: &sync.Map{}}
+		db, _ := open(t, &Config{Cacher: cacher})
+		var users []tests.User
+		if err := db.Session(&gorm.Session{DryRun: true}).Find(&users).Error; err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		cacher.store.Range(func(key, _ any) bool {
+			t.Errorf("nothing was expected to be stored, got `%s`", key)
+			return true
+		})
+	})
+
+	t.Run("tenant", func(t *testing.T) {
+		db, caches := open(t, &Config{Cacher: &cacherMock{}, TenantFromContext: func(ctx context.Context) (string, bool) {
+			return "acme", true
+		}})
+		info := caches.ExplainKey(db.Model(&tests.User{}).Scopes(Cache("users")))
+		if info.Key != "tenant:acme:users" || !reflect.DeepEqual(info.Tags, []string{tenantTag("acme")}) {
+			t.Errorf("expected the key and tags of the tenant, got %+v", info)
+		}
+	})
+
+	t.Run("skipped", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			conf  *Config
+			query func(db *gorm.DB) *gorm.DB
+		}{
+			"no cacher": {
+				conf: &Config{Easer: true},
+				query: func(db *gorm.DB) *gorm.DB {
+					return db.Model(&tests.User{})
+				},
+			},
+			"unbound placeholder": {
+				conf: &Config{Cacher: &cacherMock{}},
+				query: func(db *gorm.DB) *gorm.DB {
+					return db.Model(&tests.User{}).Scopes(Cache("user:{id}"))
+				},
+			},
+			"key builder": {
+				conf: &Config{Cacher: &cacherMock{}, KeyBuilder: func(*gorm.DB) (string, error) {
+					return "", errors.New("key-error")
+				}},
+				query: func(db *gorm.DB) *gorm.DB {
+					return db.Model(&tests.User{})
+				},
+			},
+			"no destination": {
+				conf: &Config{Cacher: &cacherMock{}},
+				query: func(db *gorm.DB) *gorm.DB {
+					return db.Table("users").Where("id = ?", 1)
+				},
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				db, caches := open(t, tc.conf)
+				info := caches.ExplainKey(tc.query(db))
+				if !info.Skipped || info.Reason == "" {
+					t.Errorf("the query was expected to be skipped with a reason, got %+v", info)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,343 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// fileMagic starts the files of FileCacher, followed by the length of the header
+var fileMagic = []byte("GCF1")
+
+// errNotCacheFile is returned for the files not starting with fileMagic
+var errNotCacheFile = fmt.Errorf("%w: not a cache file", ErrInvalidEntry)
+
+// FileCacher is a Cacher keeping each entry in a file of a directory, so that it outlives the process,
+// such as for CLI tools and batch jobs. Files are written to a temporary file then renamed,
+// under two levels of directories by the hash of their key. Their header holds the expiry,
+// the key and the tables and tags of the entry, by which they can be invalidated
+type FileCacher struct {
+	dir string
+	now func() time.Time
+	janitor
+}
+
+// NewFileCacher returns a FileCacher keeping its entries under dir, which is created when missing.
+// Expired entries are removed when read, by Cleanup, and every janitorInterval unless it is zero.
+// Close stops the janitor
+func NewFileCacher(dir string, janitorInterval time.Duration) (*FileCacher, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := &FileCacher{dir: dir, now: time.Now, janitor: newJanitor()}
+	c.janitor.start(janitorInterval, func() {
+		_ = c.Cleanup(context.Background())
+	})
+	return c, nil
+}
+
+// fileHeader is what FileCacher tells about an entry, ahead of its value
+type fileHeader struct {
+	expires time.Time
+	key     string
+	tables  []string
+	tags    []string
+}
+
+func (h fileHeader) expired(now time.Time) bool {
+	return !h.expires.IsZero() && !now.Before(h.expires)
+}
+
+func (c *FileCacher) Get(_ context.Context, key string, q *Query[any]) (*Query[any], error) {
+	path := c.path(key)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	header, value, err := decodeFileEntry(data)
+	if err != nil {
+		return nil, err
+	}
+	if header.key != key {
+		// A collision of hashes, as unlikely as it is
+		return nil, nil
+	}
+	if header.expired(c.now()) {
+		_ = removeFile(path)
+		return nil, nil
+	}
+	if err := q.Unmarshal(value); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Store writes val to a temporary file renamed to the file of key, so that it is never read partially
+func (c *FileCacher) Store(_ context.Context, key string, val *Query[any], d ...time.Duration) error {
+	value, err := val.Marshal()
+	if err != nil {
+		return err
+	}
+	header := fileHeader{key: key, tables: val.Metadata().Tables, tags: val.Metadata().Tags}
+	if len(d) > 0 && d[0] > 0 {
+		header.expires = c.now().Add(d[0])
+	}
+
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(encodeFileEntry(header, value))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Invalidate removes every entry
+func (c *FileCacher) Invalidate(ctx context.Context) error {
+	return c.remove(ctx, func(fileHeader) bool { return true })
+}
+
+func (c *FileCacher) Delete(_ context.Context, key string) error {
+	return removeFile(c.path(key))
+}
+
+func (c *FileCacher) InvalidateTags(ctx context.Context, tags ...string) error {
+	return c.remove(ctx, func(h fileHeader) bool {
+		return slices.ContainsFunc(h.tags, func(tag string) bool { return slices.Contains(tags, tag) })
+	})
+}
+
+// InvalidateTables removes the entries of the queries which read any of the tables
+func (c *FileCacher) InvalidateTables(ctx context.Context, tables ...string) error {
+	return c.remove(ctx, func(h fileHeader) bool {
+		return slices.ContainsFunc(h.tables, func(table string) bool { return slices.Contains(tables, table) })
+	})
+}
+
+// InvalidatePrefix removes the entries whose key starts with prefix
+func (c *FileCacher) InvalidatePrefix(ctx context.Context, prefix string) error {
+	return c.remove(ctx, func(h fileHeader) bool { return strings.HasPrefix(h.key, prefix) })
+}
+
+// Cleanup removes the expired entries, along with the temporary files left by interrupted writes
+func (c *FileCacher) Cleanup(ctx context.Context) error {
+	now := c.now()
+	return c.remove(ctx, func(h fileHeader) bool { return h.expired(now) })
+}
+
+// remove walks the entries, removing the ones matched by their header.
+// Only the files of the entries and the temporary ones are looked at, under the directories of
+// the hashes, so that the other files of dir are left alone
+func (c *FileCacher) remove(ctx context.Context, match func(fileHeader) bool) error {
+	// Temporary files older than this are left by interrupted writes
+	stale := c.now().Add(-time.Hour)
+	shards, err := hashDirs(c.dir)
+	if err != nil {
+		return err
+	}
+	for _, shard := range shards {
+		files, err := hashDirs(shard)
+		if err != nil {
+			return err
+		}
+		for _, dir := range files {
+			if err := c.removeIn(ctx, dir, stale, match); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *FileCacher) removeIn(ctx context.Context, dir string, stale time.Time, match func(fileHeader) bool) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, d := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		path := filepath.Join(dir, d.Name())
+		switch {
+		case !d.Type().IsRegular():
+		case strings.HasPrefix(d.Name(), ".tmp-"):
+			if info, err := d.Info(); err == nil && info.ModTime().Before(stale) {
+				if err := removeFile(path); err != nil {
+					return err
+				}
+			}
+		case isHex(d.Name(), sha256.Size*2):
+			header, err := readFileHeader(path)
+			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, errNotCacheFile) {
+				continue
+			}
+			if errors.Is(err, ErrInvalidEntry) || (err == nil && match(header)) {
+				err = removeFile(path)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hashDirs returns the directories of dir named by 2 hex digits, as made by path
+func hashDirs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var dirs []string
+	for _, d := range entries {
+		if d.IsDir() && isHex(d.Name(), 2) {
+			dirs = append(dirs, filepath.Join(dir, d.Name()))
+		}
+	}
+	return dirs, nil
+}
+
+// isHex reports whether name is made of n lowercase hex digits
+func isHex(name string, n int) bool {
+	if len(name) != n {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if (name[i] < '0' || name[i] > '9') && (name[i] < 'a' || name[i] > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// path returns dir/ab/cd/abcd..., by the hash of key
+func (c *FileCacher) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.dir, name[:2], name[2:4], name)
+}
+
+func removeFile(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// encodeFileEntry returns magic | header length | expiry | key | tables | tags | value
+func encodeFileEntry(h fileHeader, value []byte) []byte {
+	var header bytes.Buffer
+	var expires int64
+	if !h.expires.IsZero() {
+		expires = h.expires.UnixNano()
+	}
+	header.Write(binary.BigEndian.AppendUint64(nil, uint64(expires)))
+	writeStrings(&header, []string{h.key})
+	writeStrings(&header, h.tables)
+	writeStrings(&header, h.tags)
+
+	buf := make([]byte, 0, len(fileMagic)+4+header.Len()+len(value))
+	buf = append(buf, fileMagic...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(header.Len()))
+	buf = append(buf, header.Bytes()...)
+	return append(buf, value...)
+}
+
+func decodeFileEntry(data []byte) (fileHeader, []byte, error) {
+	size, err := fileHeaderSize(data)
+	if err != nil {
+		return fileHeader{}, nil, err
+	}
+	if len(data) < size {
+		return fileHeader{}, nil, fmt.Errorf("%w: truncated file", ErrInvalidEntry)
+	}
+	header, err := decodeFileHeader(data[len(fileMagic)+4 : size])
+	return header, data[size:], err
+}
+
+// fileHeaderSize returns the size of the magic, the header length and the header
+func fileHeaderSize(data []byte) (int, error) {
+	if len(data) < len(fileMagic)+4 || !bytes.HasPrefix(data, fileMagic) {
+		return 0, errNotCacheFile
+	}
+	return len(fileMagic) + 4 + int(binary.BigEndian.Uint32(data[len(fileMagic):])), nil
+}
+
+func readFileHeader(path string) (fileHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return fileHeader{}, err
+	}
+	defer f.Close()
+
+	prefix := make([]byte, len(fileMagic)+4)
+	if _, err := io.ReadFull(f, prefix); err != nil {
+		return fileHeader{}, fmt.Errorf("%w: truncated file", ErrInvalidEntry)
+	}
+	size, err := fileHeaderSize(prefix)
+	if err != nil {
+		return fileHeader{}, err
+	}
+	header := make([]byte, size-len(prefix))
+	if _, err := io.ReadFull(f, header); err != nil {
+		return fileHeader{}, fmt.Errorf("%w: truncated file", ErrInvalidEntry)
+	}
+	return decodeFileHeader(header)
+}
+
+func decodeFileHeader(data []byte) (fileHeader, error) {
+	if len(data) < 8 {
+		return fileHeader{}, fmt.Errorf("%w: truncated header", ErrInvalidEntry)
+	}
+	var h fileHeader
+	if expires := int64(binary.BigEndian.Uint64(data)); expires != 0 {
+		h.expires = time.Unix(0, expires)
+	}
+	r := bytes.NewReader(data[8:])
+	key, err := readStrings(r)
+	if err != nil || len(key) != 1 {
+		return fileHeader{}, fmt.Errorf("%w: invalid key", ErrInvalidEntry)
+	}
+	h.key = key[0]
+	if h.tables, err = readStrings(r); err != nil {
+		return fileHeader{}, err
+	}
+	if h.tags, err = readStrings(r); err != nil {
+		return fileHeader{}, err
+	}
+	return h, nil
+}
@@ -0,0 +1,245 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newFileCacher(t *testing.T) *FileCacher {
+	c, err := NewFileCacher(filepath.Join(t.TempDir(), "cache"), 0)
+	if err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	return c
+}
+
+func fileQuery(val int, tables, tags []string) *Query[any] {
+	return &Query[any]{Dest: val, RowsAffected: 1, meta: Metadata{Tables: tables, Tags: tags}}
+}
+
+func countFiles(t *testing.T, dir string) int {
+	t.Helper()
+	n := 0
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, _ error) error {
+		if d != nil && !d.IsDir() {
+			n++
+		}
+		return nil
+	})
+	return n
+}
+
+// assertKept checks that the keys of kept are the only ones of all returned by c
+func assertKept(t *testing.T, c Cacher, all []string, kept ...string) {
+	t.Helper()
+	for _, key := range all {
+		_, ok := lruGet(t, c, key)
+		if expected := slices.Contains(kept, key); ok != expected {
+			t.Errorf("`%s` expected to be kept: %t", key, expected)
+		}
+	}
+}
+
+func TestFileCacher(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("store and get", func(t *testing.T) {
+		c := newFileCacher(t)
+		if _, ok := lruGet(t, c, "a"); ok {
+			t.Error("a miss was expected")
+		}
+		if err := c.Store(ctx, "a", lruQuery(42)); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if val, ok := lruGet(t, c, "a"); !ok || val != 42 {
+			t.Errorf("expected 42 to be returned, got %d, %t", val, ok)
+		}
+
+		rel, _ := filepath.Rel(c.dir, c.path("a"))
+		if parts := strings.Split(rel, string(filepath.Separator)); len(parts) != 3 || !strings.HasPrefix(parts[2], parts[0]+parts[1]) {
+			t.Errorf("expected the file to be sharded by the hash of its key, got %s", rel)
+		}
+
+		// Outlives the FileCacher
+		other, _ := NewFileCacher(c.dir, 0)
+		if val, ok := lruGet(t, other, "a"); !ok || val != 42 {
+			t.Errorf("expected 42 to be returned by another FileCacher, got %d, %t", val, ok)
+		}
+
+		_ = c.Delete(ctx, "a")
+		if _, ok := lruGet(t, c, "a"); ok {
+			t.Error("the entry was expected to be deleted")
+		}
+		if n := countFiles(t, c.dir); n != 0 {
+			t.Errorf("expected no file to be left, got %d", n)
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		c := newFileCacher(t)
+		now := time.Now()
+		c.now = func() time.Time { return now }
+
+		_ = c.Store(ctx, "short", lruQuery(1), time.Second)
+		_ = c.Store(ctx, "long", lruQuery(2), time.Hour)
+		_ = c.Store(ctx, "forever", lruQuery(3))
+		now = now.Add(time.Minute)
+
+		if _, ok := lruGet(t, c, "short"); ok {
+			t.Error("the expired entry was not expected to be returned")
+		}
+		if n := countFiles(t, c.dir); n != 2 {
+			t.Errorf("the expired entry was expected to be removed when read, got %d files", n)
+		}
+
+		now = now.Add(2 * time.Hour)
+		if err := c.Cleanup(ctx); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if n := countFiles(t, c.dir); n != 1 {
+			t.Errorf("the expired entries were expected to be cleaned up, got %d files", n)
+		}
+		if _, ok := lruGet(t, c, "forever"); !ok {
+			t.Error("the entry without TTL was expected to be kept")
+		}
+	})
+
+	t.Run("janitor", func(t *testing.T) {
+		c, err := NewFileCacher(t.TempDir(), time.Millisecond)
+		if err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		defer c.Close()
+		_ = c.Store(ctx, "a", lruQuery(1), time.Millisecond)
+
+		deadline := time.Now().Add(time.Second)
+		for countFiles(t, c.dir) != 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if n := countFiles(t, c.dir); n != 0 {
+			t.Errorf("the janitor was expected to remove the expired entry, got %d files", n)
+		}
+	})
+
+	t.Run("invalidation", func(t *testing.T) {
+		c := newFileCacher(t)
+		store := func() {
+			_ = c.Store(ctx, "users:1", fileQuery(1, []string{"users"}, []string{"tenant:a"}))
+			_ = c.Store(ctx, "users:2", fileQuery(2, []string{"users", "pets"}, []string{"tenant:b"}))
+			_ = c.Store(ctx, "pets:1", fileQuery(3, []string{"pets"}, nil))
+		}
+		kept := func(keys ...string) {
+			t.Helper()
+			assertKept(t, c, []string{"users:1", "users:2", "pets:1"}, keys...)
+		}
+
+		store()
+		_ = c.InvalidateTables(ctx, "pets")
+		kept("users:1")
+
+		store()
+		_ = c.InvalidatePrefix(ctx, "users:")
+		kept("pets:1")
+
+		store()
+		_ = c.InvalidateTags(ctx, "tenant:b")
+		kept("users:1", "pets:1")
+
+		store()
+		_ = c.Invalidate(ctx)
+		kept()
+	})
+
+	t.Run("invalid files", func(t *testing.T) {
+		c := newFileCacher(t)
+		_ = c.Store(ctx, "a", lruQuery(1))
+		// Truncated
+		if err := os.WriteFile(c.path("a"), append([]byte("GCF1"), 0, 0, 0, 64), 0o644); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if _, err := c.Get(ctx, "a", &Query[any]{}); !errors.Is(err, ErrInvalidEntry) {
+			t.Errorf("expected ErrInvalidEntry, got %v", err)
+		}
+
+		// Left by an interrupted write
+		tmp := filepath.Join(filepath.Dir(c.path("a")), ".tmp-123")
+		_ = os.WriteFile(tmp, []byte("partial"), 0o644)
+		old := time.Now().Add(-2 * time.Hour)
+		_ = os.Chtimes(tmp, old, old)
+
+		if err := c.Cleanup(ctx); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if n := countFiles(t, c.dir); n != 0 {
+			t.Errorf("expected the invalid and temporary files to be removed, got %d files", n)
+		}
+	})
+
+	t.Run("other files", func(t *testing.T) {
+		c := newFileCacher(t)
+		_ = c.Store(ctx, "a", lruQuery(1))
+		others := []string{
+			filepath.Join(c.dir, "important.txt"),
+			filepath.Join(c.dir, "sub", "notes.md"),
+			filepath.Join(filepath.Dir(c.path("a")), "notes.md"),
+			c.path("b"),
+		}
+		for _, path := range others {
+			_ = os.MkdirAll(filepath.Dir(path), 0o755)
+			if err := os.WriteFile(path, []byte("not a cache file"), 0o644); err != nil {
+				t.Fatalf("an unexpected error has occurred, %v", err)
+			}
+		}
+
+		if err := c.Invalidate(ctx); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if _, ok := lruGet(t, c, "a"); ok {
+			t.Error("the entry was expected to be invalidated")
+		}
+		for _, path := range others {
+			if _, err := os.Stat(path); err != nil {
+				t.Errorf("expected %s to be kept, %v", path, err)
+			}
+		}
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		c := newFileCacher(t)
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					key := fmt.Sprintf("key-%d", (i+j)%5)
+					if err := c.Store(ctx, key, lruQuery(j, key)); err != nil {
+						t.Errorf("an unexpected error has occurred, %v", err)
+						return
+					}
+					if j%10 == 0 {
+						if err := c.InvalidateTags(ctx, key); err != nil {
+							t.Errorf("an unexpected error has occurred, %v", err)
+							return
+						}
+					}
+					var dest int
+					if _, err := c.Get(ctx, key, &Query[any]{Dest: &dest}); err != nil {
+						t.Errorf("entries were not expected to be read partially, %v", err)
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+	})
+}
@@ -2,10 +2,16 @@ module github.com/starfork/gorm-cache
 
 go 1.24.5
 
-require gorm.io/gorm v1.30.0
+require (
+	go.etcd.io/bbolt v1.4.3
+	gorm.io/driver/sqlite v1.6.0
+	gorm.io/gorm v1.30.0
+)
 
 require (
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
+	github.com/mattn/go-sqlite3 v1.14.22 // indirect
+	golang.org/x/sys v0.29.0 // indirect
 	golang.org/x/text v0.27.0 // indirect
 )
@@ -1,9 +1,18 @@
 package cache
 
 import (
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
 	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"gorm.io/gorm/callbacks"
 
@@ -12,8 +21,86 @@ import (
 
 const IdentifierPrefix = "gorm-caches::"
 
-func (c *Caches) buildIdentifier(db *gorm.DB) string {
-	return buildIdentifier(db, c.Conf.Pfx)
+// KeyHasher makes identifiers of a bounded length, look at Config.KeyHasher,
+// any hash.Hash constructor fits such as the one of an xxhash implementation
+type KeyHasher func() hash.Hash
+
+var (
+	// SHA256Hasher makes identifiers of 64 hexadecimal characters after the table
+	SHA256Hasher KeyHasher = sha256.New
+	// FNV64Hasher is a fast non-cryptographic hasher, with 16 hexadecimal characters
+	FNV64Hasher KeyHasher = func() hash.Hash { return fnv.New64a() }
+	// FNV128Hasher is a fast non-cryptographic hasher, with 32 hexadecimal characters
+	FNV128Hasher KeyHasher = func() hash.Hash { return fnv.New128a() }
+)
+
+// KeyBuilder returns the identifier under which the result of a query is cached,
+// look at Config.KeyBuilder
+type KeyBuilder func(db *gorm.DB) (string, error)
+
+// NewKeyBuilder returns the default KeyBuilder, which identifies queries by their SQL and arguments,
+// it can be wrapped by custom ones to add to or alter its identifiers
+func NewKeyBuilder(prefix string, hasher KeyHasher) KeyBuilder {
+	return func(db *gorm.DB) (string, error) {
+		if hasher != nil {
+			return buildHashedIdentifier(db, hasher, prefix), nil
+		}
+		return buildIdentifier(db, prefix), nil
+	}
+}
+
+func (c *Caches) buildIdentifier(db *gorm.DB) (string, error) {
+	normalizer := NormalizeWhitespace
+	if c.Conf.Normalizer != nil {
+		normalizer = c.Conf.Normalizer
+	}
+	defer normalize(db, normalizer)()
+
+	scope := c.tenantScope(db.Statement.Context)
+	if c.Conf.KeyBuilder != nil {
+		key, err := c.Conf.KeyBuilder(db)
+		return scope + key, err
+	}
+	pfx := IdentifierPrefix
+	if c.Conf.Pfx != "" {
+		pfx = c.Conf.Pfx
+	}
+	return NewKeyBuilder(pfx+c.databaseOf(db)+":"+scope, c.Conf.KeyHasher)(db)
+}
+
+// databaseOf returns Config.Database, or the name of the dialector along with the hash of its DSN
+func (c *Caches) databaseOf(db *gorm.DB) string {
+	if c.Conf.Database != "" {
+		return c.Conf.Database
+	}
+	c.databaseOnce.Do(func() {
+		c.database = databaseName(db.Dialector)
+	})
+	return c.database
+}
+
+func databaseName(dialector gorm.Dialector) string {
+	if dialector == nil {
+		return ""
+	}
+	name := dialector.Name()
+
+	// The DSN is held by the dialectors of gorm.io/driver, directly or by their Config
+	v := reflect.Indirect(reflect.ValueOf(dialector))
+	if v.Kind() != reflect.Struct {
+		return name
+	}
+	field, ok := v.Type().FieldByName("DSN")
+	if !ok || field.Type.Kind() != reflect.String {
+		return name
+	}
+	dsn, err := v.FieldByIndexErr(field.Index)
+	if err != nil || dsn.String() == "" {
+		return name
+	}
+	h := fnv.New32a()
+	_, _ = io.WriteString(h, dsn.String())
+	return name + "@" + hex.EncodeToString(h.Sum(nil))
 }
 func buildIdentifier(db *gorm.DB, prefix ...string) string {
 	// Build query identifier,
@@ -27,35 +114,193 @@ func buildIdentifier(db *gorm.DB, prefix ...string) string {
 		pfx = prefix[0]
 	}
 	identifier := fmt.Sprintf("%s%s-%s", pfx, query, queryArgs)
+	if db.Statement.Dest != nil {
+		// Results of different types are not interchangeable
+		identifier += "-" + typeName(reflect.TypeOf(db.Statement.Dest))
+	}
 	return identifier
 }
 
-func valueToString(value any) string {
-	valueOf := reflect.ValueOf(value)
-	switch valueOf.Kind() {
+// buildHashedIdentifier returns prefix + table + ":" + hash,
+// the SQL query and its arguments are streamed into the hasher
+func buildHashedIdentifier(db *gorm.DB, hasher KeyHasher, prefix ...string) string {
+	callbacks.BuildQuerySQL(db)
+	h := hasher()
+	// Length prefixed, so that the query and its arguments can not be told apart differently
+	query := db.Statement.SQL.String()
+	var buf [512]byte
+	_, _ = h.Write(strconv.AppendInt(buf[:0], int64(len(query)), 10))
+	_, _ = h.Write([]byte{':'})
+	writeChunked(h, query, buf[:])
+	writeValue(h, db.Statement.Vars)
+	if db.Statement.Dest != nil {
+		_, _ = io.WriteString(h, "-"+typeName(reflect.TypeOf(db.Statement.Dest)))
+	}
+	pfx := IdentifierPrefix
+	if len(prefix) > 0 && prefix[0] != "" {
+		pfx = prefix[0]
+	}
+	return pfx + db.Statement.Table + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// writeChunked writes s through buf, hashes rarely implementing io.StringWriter,
+// so that it is not copied at once as io.WriteString would
+func writeChunked(w io.Writer, s string, buf []byte) {
+	for len(s) > 0 {
+		n := copy(buf, s)
+		_, _ = w.Write(buf[:n])
+		s = s[n:]
+	}
+}
+
+// typeName is the String of t, but with the package paths of named types
+func typeName(t reflect.Type) string {
+	if t.Name() != "" {
+		if t.PkgPath() != "" {
+			return t.PkgPath() + "." + t.Name()
+		}
+		return t.Name()
+	}
+	switch t.Kind() {
 	case reflect.Ptr:
-		if valueOf.IsNil() {
-			return "<nil>"
+		return "*" + typeName(t.Elem())
+	case reflect.Slice:
+		return "[]" + typeName(t.Elem())
+	case reflect.Array:
+		return "[" + strconv.Itoa(t.Len()) + "]" + typeName(t.Elem())
+	case reflect.Map:
+		return "map[" + typeName(t.Key()) + "]" + typeName(t.Elem())
+	default:
+		return t.String()
+	}
+}
+
+func valueToString(value any) string {
+	var sb strings.Builder
+	writeValue(&sb, value)
+	return sb.String()
+}
+
+// writeValue writes the canonical encoding of value, where each value is tagged with its kind,
+// strings are quoted, maps are sorted by key and pointers are followed,
+// so that it is deterministic and distinct values can not be written the same way
+//
+//	nil | b:true | i:-1 | u:1 | f:1.5 | s:"a b" | x:0aff | t:2006-01-02T15:04:05Z
+//	v:<driver.Value> | [<value>,...] | {<key>=<value>,...} | T"pkg.Type"{Field=<value>,...}
+func writeValue(w io.Writer, value any) {
+	writeReflectValue(w, reflect.ValueOf(value))
+}
+
+// isNil reports whether v is of a nillable kind and nil, Value being unsafe to call on a nil Valuer
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return v.IsNil()
+	}
+	return false
+}
+
+func writeReflectValue(w io.Writer, v reflect.Value) {
+	if !v.IsValid() {
+		_, _ = io.WriteString(w, "nil")
+		return
+	}
+
+	if v.Kind() == reflect.Interface {
+		// Such as the elements of Statement.Vars, the values they hold are encoded
+		if v.IsNil() {
+			_, _ = io.WriteString(w, "nil")
+			return
+		}
+		v = v.Elem()
+	}
+
+	if v.CanInterface() {
+		switch val := v.Interface().(type) {
+		case time.Time:
+			_, _ = io.WriteString(w, "t:"+val.Format(time.RFC3339Nano))
+			return
+		case []byte:
+			_, _ = io.WriteString(w, "x:"+hex.EncodeToString(val))
+			return
+		case driver.Valuer:
+			if isNil(v) {
+				_, _ = io.WriteString(w, "nil")
+				return
+			}
+			if dv, err := val.Value(); err == nil {
+				_, _ = io.WriteString(w, "v:")
+				writeReflectValue(w, reflect.ValueOf(dv))
+				return
+			}
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			_, _ = io.WriteString(w, "nil")
+			return
 		}
-		return valueToString(valueOf.Elem().Interface())
+		writeReflectValue(w, v.Elem())
+	case reflect.Bool:
+		_, _ = io.WriteString(w, "b:"+strconv.FormatBool(v.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		_, _ = io.WriteString(w, "i:"+strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		_, _ = io.WriteString(w, "u:"+strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		_, _ = io.WriteString(w, "f:"+strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()))
+	case reflect.Complex64, reflect.Complex128:
+		_, _ = io.WriteString(w, "c:"+strconv.FormatComplex(v.Complex(), 'g', -1, v.Type().Bits()))
+	case reflect.String:
+		_, _ = io.WriteString(w, "s:"+strconv.Quote(v.String()))
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			_, _ = io.WriteString(w, "x:"+hex.EncodeToString(v.Bytes()))
+			return
+		}
+		_, _ = io.WriteString(w, "[")
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				_, _ = io.WriteString(w, ",")
+			}
+			writeReflectValue(w, v.Index(i))
+		}
+		_, _ = io.WriteString(w, "]")
 	case reflect.Map:
-		var sb strings.Builder
-		sb.WriteString("{")
-		for i, key := range valueOf.MapKeys() {
+		entries := make([][2]string, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			var key, val strings.Builder
+			writeReflectValue(&key, iter.Key())
+			writeReflectValue(&val, iter.Value())
+			entries = append(entries, [2]string{key.String(), val.String()})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i][0] < entries[j][0]
+		})
+		_, _ = io.WriteString(w, "{")
+		for i, entry := range entries {
 			if i > 0 {
-				sb.WriteString(", ")
+				_, _ = io.WriteString(w, ",")
 			}
-			sb.WriteString(fmt.Sprintf("%s: %s", valueToString(key.Interface()), valueToString(valueOf.MapIndex(key).Interface())))
+			_, _ = io.WriteString(w, entry[0]+"="+entry[1])
 		}
-		sb.WriteString("}")
-		return sb.String()
-	case reflect.Slice:
-		valueSlice := make([]any, valueOf.Len())
-		for i := range valueSlice {
-			valueSlice[i] = valueToString(valueOf.Index(i).Interface())
+		_, _ = io.WriteString(w, "}")
+	case reflect.Struct:
+		// Such as clause.Expr, along with its SQL and its own arguments
+		_, _ = io.WriteString(w, "T"+strconv.Quote(v.Type().String())+"{")
+		for i := 0; i < v.NumField(); i++ {
+			if i > 0 {
+				_, _ = io.WriteString(w, ",")
+			}
+			_, _ = io.WriteString(w, v.Type().Field(i).Name+"=")
+			writeReflectValue(w, v.Field(i))
 		}
-		return fmt.Sprintf("%v", valueSlice)
+		_, _ = io.WriteString(w, "}")
 	default:
-		return fmt.Sprintf("%v", value)
+		// Channels and functions have no value to tell them apart
+		_, _ = io.WriteString(w, "?"+strconv.Quote(v.Type().String()))
 	}
 }
@@ -1,9 +1,20 @@
 package cache
 
 import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/utils/tests"
 )
 
 func Test_buildIdentifier(t *testing.T) {
@@ -13,14 +24,14 @@ func Test_buildIdentifier(t *testing.T) {
 	db.Statement.Vars = append(db.Statement.Vars, "test", 123, 12.3, true, false, []string{"test", "me"})
 
 	actual := buildIdentifier(db)
-	expected := "gorm-caches::TEST-SQL-[test 123 12.3 true false [test me]]"
+	expected := `gorm-caches::TEST-SQL-[s:"test",i:123,f:12.3,b:true,b:false,[s:"test",s:"me"]]`
 	if actual != expected {
 		t.Errorf("buildIdentifier expected to return `%s` but got `%s`", expected, actual)
 	}
 }
 
 func Test_sliceToString(t *testing.T) {
-	expected := "[test-val test-val 1 1 true true [test-val] [1] [true] [test-val] [1] [true] [test-val] [1] [true] [test-val] [1] [true] {test-val: test-val} {1: 1} {true: true} {test-val: test-val} {1: 1} {true: true} {test-val: test-val} {1: 1} {true: true} {test-val: test-val} {1: 1} {true: true}]"
+	expected := `[s:"test-val",s:"test-val",i:1,i:1,b:true,b:true,[s:"test-val"],[i:1],[b:true],[s:"test-val"],[i:1],[b:true],[s:"test-val"],[i:1],[b:true],[s:"test-val"],[i:1],[b:true],{s:"test-val"=s:"test-val"},{i:1=i:1},{b:true=b:true},{s:"test-val"=s:"test-val"},{i:1=i:1},{b:true=b:true},{s:"test-val"=s:"test-val"},{i:1=i:1},{b:true=b:true},{s:"test-val"=s:"test-val"},{i:1=i:1},{b:true=b:true}]`
 
 	strVal := "test-val"
 	intVal := 1
@@ -53,3 +64,338 @@ func Test_sliceToString(t *testing.T) {
 		t.Errorf("sliceToString expected to return `%s` but got `%s`", expected, actual)
 	}
 }
+
+func Test_buildHashedIdentifier(t *testing.T) {
+	newDB := func(sql string, vars ...any) *gorm.DB {
+		db := &gorm.DB{}
+		db.Statement = &gorm.Statement{Table: "users"}
+		db.Statement.SQL.WriteString(sql)
+		db.Statement.Vars = vars
+		return db
+	}
+	longSQL := "SELECT * FROM users WHERE " + strings.Repeat("name = ? OR ", 1000) + "1 = 1"
+
+	for name, hasher := range map[string]KeyHasher{
+		"sha256": SHA256Hasher,
+		"fnv64":  FNV64Hasher,
+		"fnv128": FNV128Hasher,
+	} {
+		t.Run(name, func(t *testing.T) {
+			actual := buildHashedIdentifier(newDB(longSQL, "test", 123), hasher, "pfx:")
+			h := hasher()
+			h.Write([]byte(fmt.Sprintf(`%d:%s[s:"test",i:123]`, len(longSQL), longSQL)))
+			expected := "pfx:users:" + hex.EncodeToString(h.Sum(nil))
+			if actual != expected {
+				t.Errorf("buildHashedIdentifier expected to return `%s` but got `%s`", expected, actual)
+			}
+			if len(actual) > 250 {
+				t.Errorf("buildHashedIdentifier expected to return at most 250 bytes, got %d", len(actual))
+			}
+
+			if other := buildHashedIdentifier(newDB(longSQL, "test", 124), hasher, "pfx:"); other == actual {
+				t.Errorf("buildHashedIdentifier expected different arguments to make different identifiers, got `%s`", other)
+			}
+		})
+	}
+
+	t.Run("not copied", func(t *testing.T) {
+		h, buf := SHA256Hasher(), make([]byte, 512)
+		if allocs := testing.AllocsPerRun(10, func() { writeChunked(h, longSQL, buf) }); allocs != 0 {
+			t.Errorf("expected the query to be hashed without copying it, got %.0f allocations", allocs)
+		}
+	})
+
+	t.Run("default prefix", func(t *testing.T) {
+		actual := buildHashedIdentifier(newDB("TEST-SQL"), FNV64Hasher)
+		if !strings.HasPrefix(actual, IdentifierPrefix+"users:") {
+			t.Errorf("buildHashedIdentifier expected to start with `%s`, got `%s`", IdentifierPrefix+"users:", actual)
+		}
+	})
+}
+
+func Test_writeValue(t *testing.T) {
+	t.Run("distinct", func(t *testing.T) {
+		testCases := map[string][2]any{
+			"slice split":        {[]string{"a b"}, []string{"a", "b"}},
+			"string and int":     {"1", 1},
+			"int and uint":       {-1, uint(1)},
+			"string and bytes":   {"ab", []byte("ab")},
+			"nil and string":     {nil, "<nil>"},
+			"nested slices":      {[]any{[]any{1}, 2}, []any{[]any{1, 2}}},
+			"quotes":             {[]string{`a","b`}, []string{"a", "b"}},
+			"map entries":        {map[string]string{"a": "b=c"}, map[string]string{"a=b": "c"}},
+			"time zones":         {time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 0, 0, 0, 0, time.FixedZone("", 3600))},
+			"valuer":             {sql.NullString{String: "a", Valid: true}, sql.NullString{String: "a"}},
+			"expression":         {clause.Expr{SQL: "NOW()"}, clause.Expr{SQL: "NOW() - ?", Vars: []any{1}}},
+			"unexported":         {struct{ a int }{1}, struct{ a int }{2}},
+			"float and int":      {1.0, 1},
+			"bool and string":    {true, "true"},
+			"nested map":         {map[string]any{"a": map[string]any{"b": 1}}, map[string]any{"a": map[string]any{"b": "1"}}},
+			"empty and nil list": {[]any{nil}, []any{}},
+		}
+		for name, tc := range testCases {
+			t.Run(name, func(t *testing.T) {
+				if a, b := valueToString(tc[0]), valueToString(tc[1]); a == b {
+					t.Errorf("valueToString expected to tell %#v and %#v apart, got `%s`", tc[0], tc[1], a)
+				}
+			})
+		}
+	})
+
+	t.Run("deterministic", func(t *testing.T) {
+		value := map[string]any{}
+		for i := 0; i < 100; i++ {
+			value[strconv.Itoa(i)] = map[int]int{i: i, i + 1: i + 1}
+		}
+		expected := valueToString(value)
+		for i := 0; i < 10; i++ {
+			if actual := valueToString(value); actual != expected {
+				t.Fatalf("valueToString expected to be deterministic, got `%s` then `%s`", expected, actual)
+			}
+		}
+	})
+
+	t.Run("pointers", func(t *testing.T) {
+		val := "test"
+		var nilVal *string
+		if valueToString(&val) != valueToString(val) {
+			t.Error("valueToString expected to follow pointers")
+		}
+		if valueToString(nilVal) != "nil" {
+			t.Errorf("valueToString expected to return `nil` for a nil pointer, got `%s`", valueToString(nilVal))
+		}
+	})
+
+	t.Run("nil valuers", func(t *testing.T) {
+		vars := []any{(*sql.NullString)(nil), (*time.Time)(nil), driver.Valuer(nil)}
+		if actual := valueToString(vars); actual != "[nil,nil,nil]" {
+			t.Errorf("valueToString expected to return `nil` for nil valuers, got `%s`", actual)
+		}
+
+		db := openCachedDB(t, &Config{Cacher: &cacherMock{}})
+		var users []tests.User
+		if err := db.Where("name = ? AND birthday = ?", (*sql.NullString)(nil), (*time.Time)(nil)).Find(&users).Error; err != nil {
+			t.Errorf("an unexpected error has occurred, %v", err)
+		}
+	})
+}
+
+// fuzzValue builds a value out of the fuzzer's data, it is made of the types
+// whose distinct values reflect.DeepEqual tells apart
+func fuzzValue(data []byte, depth int) (any, []byte) {
+	if len(data) == 0 {
+		return nil, data
+	}
+	kind, data := data[0]%9, data[1:]
+	take := func() []byte {
+		if len(data) == 0 {
+			return []byte{}
+		}
+		n := int(data[0]) % 8
+		data = data[1:]
+		if n > len(data) {
+			n = len(data)
+		}
+		res := append([]byte{}, data[:n]...)
+		data = data[n:]
+		return res
+	}
+
+	switch kind {
+	case 0:
+		return nil, data
+	case 1:
+		b := take()
+		return len(b)%2 == 0, data
+	case 2:
+		return int64(len(take())) - 4, data
+	case 3:
+		return float64(len(take())) / 2, data
+	case 4:
+		return string(take()), data
+	case 5:
+		return take(), data
+	case 6:
+		return time.Unix(int64(len(take())), 0).UTC(), data
+	case 7:
+		if depth > 3 {
+			return string(take()), data
+		}
+		var list []any
+		n := len(take())
+		for i := 0; i < n; i++ {
+			var v any
+			v, data = fuzzValue(data, depth+1)
+			list = append(list, v)
+		}
+		return list, data
+	default:
+		if depth > 3 {
+			return string(take()), data
+		}
+		m := map[string]any{}
+		n := len(take())
+		for i := 0; i < n; i++ {
+			var v any
+			key := string(take())
+			v, data = fuzzValue(data, depth+1)
+			m[key] = v
+		}
+		return m, data
+	}
+}
+
+func Fuzz_writeValue(f *testing.F) {
+	f.Add([]byte{7, 2, 0, 0, 4, 3, 'a', ' ', 'b'}, []byte{7, 2, 0, 0, 4, 1, 'a', 4, 1, 'b'})
+	f.Add([]byte{4, 1, '1'}, []byte{2, 5, 0, 0, 0, 0, 0})
+	f.Add([]byte{8, 2, 0, 0, 4, 1, 'a'}, []byte{8, 1, 0, 4, 1, 'a'})
+	f.Fuzz(func(t *testing.T, a, b []byte) {
+		va, _ := fuzzValue(a, 0)
+		vb, _ := fuzzValue(b, 0)
+		sa, sb := valueToString(va), valueToString(vb)
+		if equal := reflect.DeepEqual(va, vb); equal != (sa == sb) {
+			t.Errorf("valueToString expected %#v and %#v to be written the same way only when equal, got `%s` and `%s`", va, vb, sa, sb)
+		}
+	})
+}
+
+func TestCaches_KeyBuilder(t *testing.T) {
+	newDB := func() *gorm.DB {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Dest = &mockDest{}
+		db.Statement.SQL.WriteString("demo-query")
+		return db
+	}
+	newCaches := func(builder KeyBuilder, cacher Cacher) *Caches {
+		return &Caches{
+			Conf: &Config{Cacher: cacher, KeyBuilder: builder},
+			callbacks: map[queryType]func(db *gorm.DB){
+				uponQuery: func(db *gorm.DB) {
+					db.Statement.Dest.(*mockDest).Result = db.Statement.SQL.String()
+				},
+			},
+		}
+	}
+
+	t.Run("custom", func(t *testing.T) {
+		cacher := &cacherMock{}
+		builder := NewKeyBuilder("", FNV64Hasher)
+		caches := newCaches(func(db *gorm.DB) (string, error) {
+			key, err := builder(db)
+			return "tenant-1:" + key, err
+		}, cacher)
+
+		db := newDB()
+		caches.query(db)
+		if db.Error != nil {
+			t.Fatalf("an unexpected error has occurred, %v", db.Error)
+		}
+
+		expected := "tenant-1:" + buildHashedIdentifier(newDB(), FNV64Hasher)
+		if _, ok := cacher.store.Load(expected); !ok {
+			t.Errorf("the result was expected to be stored under `%s`", expected)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		caches := newCaches(func(*gorm.DB) (string, error) {
+			return "", errors.New("key-error")
+		}, &cacherMock{})
+
+		db := newDB()
+		caches.query(db)
+		if db.Error == nil {
+			t.Error("an error was expected, got none")
+		}
+	})
+
+	t.Run("default", func(t *testing.T) {
+		caches := newCaches(nil, &cacherMock{})
+		caches.Conf.Pfx = "pfx:"
+
+		actual, err := caches.buildIdentifier(newDB())
+		if err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if expected := buildIdentifier(newDB(), "pfx:dummy:"); actual != expected {
+			t.Errorf("buildIdentifier expected to return `%s` but got `%s`", expected, actual)
+		}
+	})
+}
+
+type dsnDialector struct {
+	tests.DummyDialector
+	DSN string
+}
+
+type dsnConfig struct {
+	DSN string
+}
+
+type configDialector struct {
+	*dsnConfig
+	tests.DummyDialector
+}
+
+func TestCaches_identity(t *testing.T) {
+	t.Run("destination type", func(t *testing.T) {
+		caches := &Caches{Conf: &Config{}}
+		newDB := func(dest any) *gorm.DB {
+			db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+			db.Statement.Dest = dest
+			db.Statement.SQL.WriteString("demo-query")
+			return db
+		}
+
+		for _, hasher := range []KeyHasher{nil, FNV64Hasher} {
+			caches.Conf.KeyHasher = hasher
+			structs, _ := caches.buildIdentifier(newDB(&[]tests.User{}))
+			maps, _ := caches.buildIdentifier(newDB(&[]map[string]any{}))
+			if structs == maps {
+				t.Errorf("buildIdentifier expected different destination types to make different identifiers, got `%s`", structs)
+			}
+			again, _ := caches.buildIdentifier(newDB(&[]tests.User{}))
+			if structs != again {
+				t.Errorf("buildIdentifier expected to return `%s` but got `%s`", structs, again)
+			}
+		}
+	})
+
+	t.Run("database", func(t *testing.T) {
+		testCases := map[string]struct {
+			dialector gorm.Dialector
+			expected  string
+		}{
+			"without dsn": {tests.DummyDialector{}, "dummy"},
+			"dsn":         {dsnDialector{DSN: "user:pass@/db"}, "dummy@"},
+			"config dsn":  {configDialector{dsnConfig: &dsnConfig{DSN: "user:pass@/db"}}, "dummy@"},
+			"nil config":  {configDialector{}, "dummy"},
+		}
+		for name, tc := range testCases {
+			t.Run(name, func(t *testing.T) {
+				actual := databaseName(tc.dialector)
+				if !strings.HasPrefix(actual, tc.expected) || (tc.expected == "dummy") != (actual == "dummy") {
+					t.Errorf("databaseName expected to return `%s...` but got `%s`", tc.expected, actual)
+				}
+			})
+		}
+
+		if databaseName(dsnDialector{DSN: "a"}) == databaseName(dsnDialector{DSN: "b"}) {
+			t.Error("databaseName expected different DSNs to make different names")
+		}
+		if strings.Contains(databaseName(dsnDialector{DSN: "user:pass@/db"}), "pass") {
+			t.Error("databaseName expected not to expose the DSN")
+		}
+	})
+
+	t.Run("configured database", func(t *testing.T) {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.SQL.WriteString("demo-query")
+
+		caches := &Caches{Conf: &Config{Database: "primary"}}
+		actual, _ := caches.buildIdentifier(db)
+		if !strings.HasPrefix(actual, IdentifierPrefix+"primary:") {
+			t.Errorf("buildIdentifier expected to start with `%s`, got `%s`", IdentifierPrefix+"primary:", actual)
+		}
+	})
+}
@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// janitor periodically drops the expired entries of the in-memory Cachers
+type janitor struct {
+	stop      chan struct{}
+	closeOnce *sync.Once
+}
+
+func newJanitor() janitor {
+	return janitor{stop: make(chan struct{}), closeOnce: &sync.Once{}}
+}
+
+// start calls drop every interval until Close, unless interval is zero
+func (j janitor) start(interval time.Duration, drop func()) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				drop()
+			case <-j.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the janitor, the entries are kept
+func (j janitor) Close() error {
+	j.closeOnce.Do(func() {
+		close(j.stop)
+	})
+	return nil
+}
@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrUnboundPlaceholder is returned when a placeholder of a key template
+// is neither bound by the Bind scope nor by an equality condition of the query
+var ErrUnboundPlaceholder = errors.New("cache: unbound key placeholder")
+
+var (
+	placeholderRe = regexp.MustCompile(`\{([^{}]+)\}`)
+	// equalityRe matches conditions such as "id = ?", "users.id = ?" or "`users`.`id` = ?"
+	equalityRe = regexp.MustCompile("^\\s*(?:[`\"]?\\w+[`\"]?\\.)?[`\"]?(\\w+)[`\"]?\\s*=\\s*\\?\\s*$")
+)
+
+// isKeyTemplate reports whether a key of the Cache scope has placeholders
+func isKeyTemplate(key string) bool {
+	return placeholderRe.MatchString(key)
+}
+
+// resolveKey replaces the placeholders of a key template, such as "user:{id}", with the bound values,
+// or else with the values the query's WHERE clause compares the named columns to.
+// The values are escaped, so that they can not contain the punctuation of the template
+// and different values can not make the same key
+func resolveKey(db *gorm.DB, template string, bindings map[string]any) (string, error) {
+	literals := placeholderRe.ReplaceAllString(template, "")
+	var conditions map[string]any
+	var err error
+	key := placeholderRe.ReplaceAllStringFunc(template, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		if val, ok := bindings[name]; ok {
+			return keyValue(val, literals)
+		}
+
+		if conditions == nil {
+			conditions = equalities(db)
+		}
+		if val, ok := conditions[columnName(db, name)]; ok {
+			return keyValue(val, literals)
+		}
+		if err == nil {
+			err = fmt.Errorf("%w: %s in %q", ErrUnboundPlaceholder, placeholder, template)
+		}
+		return placeholder
+	})
+	return key, err
+}
+
+// equalities returns the values the columns are compared to
+// by the top level AND conditions of the WHERE clause
+func equalities(db *gorm.DB) map[string]any {
+	res := make(map[string]any)
+	c, ok := db.Statement.Clauses["WHERE"]
+	if !ok {
+		return res
+	}
+	where, ok := c.Expression.(clause.Where)
+	if !ok {
+		return res
+	}
+
+	var collect func(exprs []clause.Expression)
+	collect = func(exprs []clause.Expression) {
+		for _, expr := range exprs {
+			switch e := expr.(type) {
+			case clause.AndConditions:
+				collect(e.Exprs)
+			case clause.Eq:
+				res[columnName(db, e.Column)] = e.Value
+			case clause.IN:
+				if len(e.Values) == 1 {
+					res[columnName(db, e.Column)] = e.Values[0]
+				}
+			case clause.Expr:
+				if m := equalityRe.FindStringSubmatch(e.SQL); m != nil && len(e.Vars) == 1 {
+					res[columnName(db, m[1])] = e.Vars[0]
+				}
+			}
+		}
+	}
+	for _, expr := range where.Exprs {
+		if _, ok := expr.(clause.OrConditions); ok {
+			// Conditions are not all required anymore
+			return map[string]any{}
+		}
+	}
+	collect(where.Exprs)
+	return res
+}
+
+// columnName returns the database name of a column, a field name or the primary key
+func columnName(db *gorm.DB, column any) string {
+	var name string
+	switch c := column.(type) {
+	case string:
+		name = c
+	case clause.Column:
+		name = c.Name
+	default:
+		return fmt.Sprint(column)
+	}
+
+	if s := db.Statement.Schema; s != nil {
+		if name == clause.PrimaryKey && s.PrioritizedPrimaryField != nil {
+			return s.PrioritizedPrimaryField.DBName
+		}
+		if field := s.LookUpField(name); field != nil {
+			return field.DBName
+		}
+	}
+	return strings.ToLower(name)
+}
+
+// keyValue returns the escaped value of a placeholder, <nil> for nil
+func keyValue(val any, literals string) string {
+	if valuer, ok := val.(driver.Valuer); ok && !isNil(reflect.ValueOf(val)) {
+		if v, err := valuer.Value(); err == nil {
+			val = v
+		}
+	}
+	v := reflect.ValueOf(val)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() || v.Kind() == reflect.Ptr {
+		return "<nil>"
+	}
+	return escapeKeyValue(fmt.Sprint(v.Interface()), literals)
+}
+
+// escapeKeyValue percent-encodes %, < and >, the spaces and control characters,
+// and the punctuation found in the literals of the template, such as the separators
+func escapeKeyValue(value, literals string) string {
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		alnum := c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+		if c == '%' || c == '<' || c == '>' || c <= ' ' || c >= 0x7f || (!alnum && strings.IndexByte(literals, c) >= 0) {
+			_, _ = fmt.Fprintf(&sb, "%%%02X", c)
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
@@ -0,0 +1,162 @@
+package cache
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestCaches_keyTemplate(t *testing.T) {
+	testCases := map[string]struct {
+		query    func(db *gorm.DB) *gorm.DB
+		expected string
+	}{
+		"primary key": {
+			query: func(db *gorm.DB) *gorm.DB {
+				return db.Scopes(Cache("user:{id}")).First(&tests.User{}, 10)
+			},
+			expected: "user:10",
+		},
+		"field name": {
+			query: func(db *gorm.DB) *gorm.DB {
+				return db.Scopes(Cache("user:{ID}")).Where("id = ?", 7).Find(&[]tests.User{})
+			},
+			expected: "user:7",
+		},
+		"qualified column": {
+			query: func(db *gorm.DB) *gorm.DB {
+				return db.Scopes(Cache("user:{id}")).Where("`users`.`id` = ?", 3).Find(&[]tests.User{})
+			},
+			expected: "user:3",
+		},
+		"struct conditions": {
+			query: func(db *gorm.DB) *gorm.DB {
+				return db.Scopes(Cache("users:by-name:{name}:{age}")).Where(&tests.User{Name: "jinzhu", Age: 18}).Find(&[]tests.User{})
+			},
+			expected: "users:by-name:jinzhu:18",
+		},
+		"map conditions": {
+			query: func(db *gorm.DB) *gorm.DB {
+				return db.Scopes(Cache("pets:by-user:{user_id}")).Where(map[string]any{"user_id": 5}).Find(&[]tests.Pet{})
+			},
+			expected: "pets:by-user:5",
+		},
+		"bindings": {
+			query: func(db *gorm.DB) *gorm.DB {
+				return db.Scopes(Cache("orders:by-customer:{customer_id}"), Bind("customer_id", 42)).Where("age > ?", 1).Find(&[]tests.User{})
+			},
+			expected: "orders:by-customer:42",
+		},
+		"bindings first": {
+			query: func(db *gorm.DB) *gorm.DB {
+				return db.Scopes(Cache("user:{id}"), Bind("id", "bound")).First(&tests.User{}, 10)
+			},
+			expected: "user:bound",
+		},
+		"plain key": {
+			query: func(db *gorm.DB) *gorm.DB {
+				return db.Scopes(Cache("users")).First(&tests.User{}, 10)
+			},
+			expected: "users",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cacher := &cacherMock{}
+			db := openCachedDB(t, &Config{Cacher: cacher})
+
+			if tx := tc.query(db); tx.Error != nil {
+				t.Fatalf("an unexpected error has occurred, %v", tx.Error)
+			}
+			if _, ok := cacher.store.Load(tc.expected); !ok {
+				var keys []any
+				cacher.store.Range(func(key, _ any) bool {
+					keys = append(keys, key)
+					return true
+				})
+				t.Errorf("the result was expected to be stored under `%s`, got %v", tc.expected, keys)
+			}
+		})
+	}
+
+	t.Run("escaped", func(t *testing.T) {
+		keys := map[string]bool{}
+		for _, bindings := range [][2]any{{"1:2", "3"}, {"1", "2:3"}, {"1%3A2", "3"}, {nil, "3"}, {"<nil>", "3"}} {
+			cacher := &cacherMock{store: &sync.Map{}}
+			db := openCachedDB(t, &Config{Cacher: cacher})
+			scopes := []func(*gorm.DB) *gorm.DB{Cache("a:{x}:{y}"), Bind("x", bindings[0]), Bind("y", bindings[1])}
+			if err := db.Scopes(scopes...).Find(&[]tests.User{}).Error; err != nil {
+				t.Fatalf("an unexpected error has occurred, %v", err)
+			}
+			cacher.store.Range(func(key, _ any) bool {
+				if keys[key.(string)] {
+					t.Errorf("`%s` was expected to be made by %v only", key, bindings)
+				}
+				keys[key.(string)] = true
+				return true
+			})
+		}
+		if !keys["a:1%3A2:3"] {
+			t.Errorf("expected the separator to be escaped, got %v", keys)
+		}
+	})
+
+	t.Run("nil valuers", func(t *testing.T) {
+		cacher := &cacherMock{}
+		db := openCachedDB(t, &Config{Cacher: cacher})
+		scopes := []func(*gorm.DB) *gorm.DB{Cache("users:{name}:{birthday}"), Bind("name", (*sql.NullString)(nil))}
+		if err := db.Scopes(scopes...).Where("birthday = ?", (*time.Time)(nil)).Find(&[]tests.User{}).Error; err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if _, ok := cacher.store.Load("users:<nil>:<nil>"); !ok {
+			t.Error("the result was expected to be stored under `users:<nil>:<nil>`")
+		}
+	})
+
+	t.Run("unbound", func(t *testing.T) {
+		for name, query := range map[string]func(db *gorm.DB) *gorm.DB{
+			"missing": func(db *gorm.DB) *gorm.DB {
+				return db.Scopes(Cache("user:{id}")).Where("age > ?", 1).Find(&[]tests.User{})
+			},
+			"or": func(db *gorm.DB) *gorm.DB {
+				return db.Scopes(Cache("user:{id}")).Where("id = ?", 1).Or("id = ?", 2).Find(&[]tests.User{})
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				db := openCachedDB(t, &Config{Cacher: &cacherMock{}})
+				if tx := query(db); !errors.Is(tx.Error, ErrUnboundPlaceholder) {
+					t.Errorf("expected ErrUnboundPlaceholder, got %v", tx.Error)
+				}
+			})
+		}
+	})
+}
+
+// openCachedDB returns a DB with the Caches plugin, whose queries return no rows
+func openCachedDB(t *testing.T, conf *Config) *gorm.DB {
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+	// Not a dry run session, the results of which are not cached
+	if err := db.Callback().Query().Replace("gorm:query", buildQuery); err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	if err := db.Use(&Caches{Conf: conf}); err != nil {
+		t.Fatalf("gorm:caches loading resulted into an unexpected error, %s", err.Error())
+	}
+	return db
+}
+
+// buildQuery replaces the "gorm:query" callback, it builds the SQL without running it
+func buildQuery(db *gorm.DB) {
+	callbacks.BuildQuerySQL(db)
+}
@@ -0,0 +1,216 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// LRUCacher is an in-memory Cacher holding at most a number of entries,
+// the least recently used ones are evicted to make room for new ones.
+// Entries are kept serialized, so that cached results are not shared with the callers
+type LRUCacher struct {
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]*list.Element
+	// order holds the most recently used entries first
+	order *list.List
+	// window holds the new entries while they wait for admission, look at WithAdmission
+	window    *list.List
+	admission AdmissionPolicy
+
+	now func() time.Time
+	janitor
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+	tags    []string
+	// window tells that the entry is in the admission window
+	window bool
+}
+
+func (e *lruEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// NewLRUCacher returns a LRUCacher holding at most maxEntries entries, unbounded when zero,
+// expired entries are dropped when read and every janitorInterval, unless it is zero.
+// Close stops the janitor
+func NewLRUCacher(maxEntries int, janitorInterval time.Duration) *LRUCacher {
+	c := newLRUCacher(maxEntries)
+	c.janitor.start(janitorInterval, c.dropExpired)
+	return c
+}
+
+func newLRUCacher(maxEntries int) *LRUCacher {
+	return &LRUCacher{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		window:     list.New(),
+		now:        time.Now,
+		janitor:    newJanitor(),
+	}
+}
+
+// WithAdmission makes the new entries wait in a window of 1% of the entries,
+// then they only evict the least recently used entry if policy admits them (W-TinyLFU with NewTinyLFU).
+// It must be called before the LRUCacher is used
+func (c *LRUCacher) WithAdmission(policy AdmissionPolicy) *LRUCacher {
+	c.admission = policy
+	return c
+}
+
+func (c *LRUCacher) Get(_ context.Context, key string, q *Query[any]) (*Query[any], error) {
+	if c.admission != nil {
+		c.admission.Record(key)
+	}
+	c.mu.Lock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil, nil
+	}
+	entry := elem.Value.(*lruEntry)
+	if entry.expired(c.now()) {
+		c.remove(elem)
+		c.mu.Unlock()
+		return nil, nil
+	}
+	c.listOf(entry).MoveToFront(elem)
+	value := entry.value
+	c.mu.Unlock()
+
+	if err := q.Unmarshal(value); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Store keeps val until d[0] is elapsed, if any
+func (c *LRUCacher) Store(_ context.Context, key string, val *Query[any], d ...time.Duration) error {
+	value, err := val.Marshal()
+	if err != nil {
+		return err
+	}
+	entry := &lruEntry{key: key, value: value, tags: val.Metadata().Tags}
+	if len(d) > 0 && d[0] > 0 {
+		entry.expires = c.now().Add(d[0])
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		entry.window = elem.Value.(*lruEntry).window
+		elem.Value = entry
+		c.listOf(entry).MoveToFront(elem)
+		return nil
+	}
+	if c.admission == nil || c.maxEntries <= 0 {
+		c.entries[key] = c.order.PushFront(entry)
+		for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+			c.remove(c.order.Back())
+		}
+		return nil
+	}
+
+	entry.window = true
+	c.entries[key] = c.window.PushFront(entry)
+	if c.window.Len() > c.windowSize() {
+		c.promote(c.window.Back())
+	}
+	return nil
+}
+
+func (c *LRUCacher) windowSize() int {
+	return max(1, c.maxEntries/100)
+}
+
+// promote moves the least recently used entry of the window to the main list,
+// if the admission policy prefers it to the entry it would evict, it is dropped otherwise
+func (c *LRUCacher) promote(elem *list.Element) {
+	entry := elem.Value.(*lruEntry)
+	c.window.Remove(elem)
+	entry.window = false
+	if c.order.Len() >= c.maxEntries-c.windowSize() {
+		victim := c.order.Back()
+		if victim == nil || !c.admission.Admit(entry.key, victim.Value.(*lruEntry).key) {
+			delete(c.entries, entry.key)
+			return
+		}
+		c.remove(victim)
+	}
+	c.entries[entry.key] = c.order.PushFront(entry)
+}
+
+func (c *LRUCacher) listOf(entry *lruEntry) *list.List {
+	if entry.window {
+		return c.window
+	}
+	return c.order
+}
+
+func (c *LRUCacher) Invalidate(context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+	c.window.Init()
+	return nil
+}
+
+func (c *LRUCacher) Delete(_ context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	return nil
+}
+
+func (c *LRUCacher) InvalidateTags(_ context.Context, tags ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, elem := range c.entries {
+		for _, tag := range elem.Value.(*lruEntry).tags {
+			if slices.Contains(tags, tag) {
+				c.remove(elem)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Len returns the number of entries, including the expired ones which were not dropped yet
+func (c *LRUCacher) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len() + c.window.Len()
+}
+
+func (c *LRUCacher) dropExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for _, l := range []*list.List{c.order, c.window} {
+		for elem := l.Back(); elem != nil; {
+			prev := elem.Prev()
+			if elem.Value.(*lruEntry).expired(now) {
+				c.remove(elem)
+			}
+			elem = prev
+		}
+	}
+}
+
+// remove must be called with mu held
+func (c *LRUCacher) remove(elem *list.Element) {
+	c.listOf(elem.Value.(*lruEntry)).Remove(elem)
+	delete(c.entries, elem.Value.(*lruEntry).key)
+}
@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func lruQuery(val int, tags ...string) *Query[any] {
+	return &Query[any]{Dest: val, RowsAffected: 1, meta: Metadata{Tags: tags}}
+}
+
+func lruGet(t *testing.T, c Cacher, key string) (int, bool) {
+	t.Helper()
+	var dest int
+	res, err := c.Get(context.Background(), key, &Query[any]{Dest: &dest})
+	if err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	return dest, res != nil
+}
+
+func TestLRUCacher(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("store and get", func(t *testing.T) {
+		c := NewLRUCacher(0, 0)
+		if _, ok := lruGet(t, c, "a"); ok {
+			t.Error("a miss was expected")
+		}
+		_ = c.Store(ctx, "a", lruQuery(1))
+		_ = c.Store(ctx, "a", lruQuery(2))
+		if val, ok := lruGet(t, c, "a"); !ok || val != 2 {
+			t.Errorf("expected 2 to be returned, got %d, %t", val, ok)
+		}
+		if c.Len() != 1 {
+			t.Errorf("expected 1 entry, got %d", c.Len())
+		}
+	})
+
+	t.Run("eviction", func(t *testing.T) {
+		c := NewLRUCacher(2, 0)
+		_ = c.Store(ctx, "a", lruQuery(1))
+		_ = c.Store(ctx, "b", lruQuery(2))
+		lruGet(t, c, "a")
+		_ = c.Store(ctx, "c", lruQuery(3))
+
+		if _, ok := lruGet(t, c, "b"); ok {
+			t.Error("the least recently used entry was expected to be evicted")
+		}
+		for _, key := range []string{"a", "c"} {
+			if _, ok := lruGet(t, c, key); !ok {
+				t.Errorf("`%s` was expected to be kept", key)
+			}
+		}
+		if c.Len() != 2 {
+			t.Errorf("expected 2 entries, got %d", c.Len())
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		c := NewLRUCacher(0, 0)
+		now := time.Now()
+		c.now = func() time.Time { return now }
+
+		_ = c.Store(ctx, "short", lruQuery(1), time.Second)
+		_ = c.Store(ctx, "long", lruQuery(2), time.Hour)
+		_ = c.Store(ctx, "forever", lruQuery(3))
+		now = now.Add(time.Minute)
+
+		if _, ok := lruGet(t, c, "short"); ok {
+			t.Error("the expired entry was not expected to be returned")
+		}
+		if c.Len() != 2 {
+			t.Errorf("the expired entry was expected to be dropped when read, got %d entries", c.Len())
+		}
+		for _, key := range []string{"long", "forever"} {
+			if _, ok := lruGet(t, c, key); !ok {
+				t.Errorf("`%s` was expected to be kept", key)
+			}
+		}
+	})
+
+	t.Run("janitor", func(t *testing.T) {
+		c := NewLRUCacher(0, time.Millisecond)
+		defer c.Close()
+		_ = c.Store(ctx, "a", lruQuery(1), time.Millisecond)
+		_ = c.Store(ctx, "b", lruQuery(2))
+
+		deadline := time.Now().Add(time.Second)
+		for c.Len() != 1 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if c.Len() != 1 {
+			t.Errorf("the janitor was expected to drop the expired entry, got %d entries", c.Len())
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		c := NewLRUCacher(0, time.Millisecond)
+		if err := c.Close(); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if err := c.Close(); err != nil {
+			t.Fatalf("closing twice was not expected to fail, %v", err)
+		}
+		_ = c.Store(ctx, "a", lruQuery(1))
+		if _, ok := lruGet(t, c, "a"); !ok {
+			t.Error("the entries were expected to be usable after Close")
+		}
+	})
+
+	t.Run("invalidation", func(t *testing.T) {
+		c := NewLRUCacher(0, 0)
+		_ = c.Store(ctx, "a", lruQuery(1, "tenant:a"))
+		_ = c.Store(ctx, "b", lruQuery(2, "tenant:b"))
+		_ = c.Store(ctx, "c", lruQuery(3))
+
+		_ = c.InvalidateTags(ctx, "tenant:a")
+		if _, ok := lruGet(t, c, "a"); ok {
+			t.Error("the tagged entry was expected to be invalidated")
+		}
+		_ = c.Delete(ctx, "b")
+		if _, ok := lruGet(t, c, "b"); ok {
+			t.Error("the entry was expected to be deleted")
+		}
+		if _, ok := lruGet(t, c, "c"); !ok {
+			t.Error("the other entries were expected to be kept")
+		}
+		_ = c.Invalidate(ctx)
+		if c.Len() != 0 {
+			t.Errorf("no entry was expected to be kept, got %d", c.Len())
+		}
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		c := NewLRUCacher(50, time.Millisecond)
+		defer c.Close()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 500; j++ {
+					key := fmt.Sprintf("key-%d", (i*j)%100)
+					switch j % 5 {
+					case 0:
+						_ = c.Delete(ctx, key)
+					case 1:
+						_ = c.InvalidateTags(ctx, key)
+					default:
+						_ = c.Store(ctx, key, lruQuery(j, key), time.Duration(j%3)*time.Millisecond)
+					}
+					var dest int
+					_, _ = c.Get(ctx, key, &Query[any]{Dest: &dest})
+				}
+			}(i)
+		}
+		wg.Wait()
+		if c.Len() > 50 {
+			t.Errorf("expected at most 50 entries, got %d", c.Len())
+		}
+	})
+}
@@ -0,0 +1,414 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// memcachedMaxKey is the length limit of memcached keys
+	memcachedMaxKey = 250
+	// memcachedRelativeTTL is the longest TTL memcached reads as relative seconds,
+	// longer ones are read as Unix timestamps
+	memcachedRelativeTTL = 30 * 24 * time.Hour
+)
+
+// MemcachedError is an error replied by the memcached server
+type MemcachedError string
+
+func (e MemcachedError) Error() string {
+	return "memcached: " + string(e)
+}
+
+// MemcachedOptions configures a MemcachedCacher
+type MemcachedOptions struct {
+	// Addr is the host:port of the server, localhost:11211 by default
+	Addr string
+	// Prefix is prepended to the keys, along with the version of the namespace
+	Prefix string
+	// PoolSize is the number of connections kept, 10 by default
+	PoolSize int
+	// DialTimeout bounds the connection to the server, 5s by default
+	DialTimeout time.Duration
+}
+
+// MemcachedCacher is a Cacher storing the serialized queries in memcached, speaking its text protocol.
+// As memcached can not list its keys, they embed the version of their namespace, which Invalidate bumps,
+// and entries hold the versions of their tags, which InvalidateTags bumps, so that the previous ones are missed.
+// Keys which are too long or have forbidden characters are escaped or hashed
+type MemcachedCacher struct {
+	opts MemcachedOptions
+	pool *connPool[*memcachedConn]
+	now  func() time.Time
+}
+
+// NewMemcachedCacher returns a MemcachedCacher, the connections are opened when needed
+func NewMemcachedCacher(opts MemcachedOptions) *MemcachedCacher {
+	if opts.Addr == "" {
+		opts.Addr = "localhost:11211"
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 10
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	c := &MemcachedCacher{opts: opts, now: time.Now}
+	c.pool = newConnPool(opts.PoolSize, c.dial)
+	return c
+}
+
+func (c *MemcachedCacher) Get(ctx context.Context, key string, q *Query[any]) (*Query[any], error) {
+	var value []byte
+	err := c.with(ctx, func(conn *memcachedConn) error {
+		key, err := c.key(conn, key)
+		if err != nil {
+			return err
+		}
+		values, err := conn.get(key)
+		if err != nil {
+			return err
+		}
+		entry, ok := values[key]
+		if !ok {
+			return nil
+		}
+
+		tags, payload, err := decodeMemcachedEntry(entry)
+		if err != nil {
+			return err
+		}
+		if len(tags) > 0 {
+			names := make([]string, 0, len(tags))
+			for tag := range tags {
+				names = append(names, c.tagKey(tag))
+			}
+			versions, err := conn.get(names...)
+			if err != nil {
+				return err
+			}
+			for tag, version := range tags {
+				if string(versions[c.tagKey(tag)]) != version {
+					// The tag was invalidated since
+					return conn.delete(key)
+				}
+			}
+		}
+		value = payload
+		return nil
+	})
+	if err != nil || value == nil {
+		return nil, err
+	}
+	if err := q.Unmarshal(value); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Store keeps val until d[0] is elapsed, if any, along with the versions of its tags
+func (c *MemcachedCacher) Store(ctx context.Context, key string, val *Query[any], d ...time.Duration) error {
+	value, err := val.Marshal()
+	if err != nil {
+		return err
+	}
+	var exptime int64
+	if len(d) > 0 && d[0] > 0 {
+		exptime = c.exptime(d[0])
+	}
+
+	return c.with(ctx, func(conn *memcachedConn) error {
+		key, err := c.key(conn, key)
+		if err != nil {
+			return err
+		}
+		tags := make(map[string]string)
+		for _, tag := range val.Metadata().Tags {
+			if tags[tag], err = c.version(conn, c.tagKey(tag)); err != nil {
+				return err
+			}
+		}
+		return conn.set(key, encodeMemcachedEntry(tags, value), exptime)
+	})
+}
+
+// Invalidate bumps the version of the namespace
+func (c *MemcachedCacher) Invalidate(ctx context.Context) error {
+	return c.with(ctx, func(conn *memcachedConn) error {
+		return c.bump(conn, c.namespaceKey())
+	})
+}
+
+func (c *MemcachedCacher) Delete(ctx context.Context, key string) error {
+	return c.with(ctx, func(conn *memcachedConn) error {
+		key, err := c.key(conn, key)
+		if err != nil {
+			return err
+		}
+		return conn.delete(key)
+	})
+}
+
+// InvalidateTags bumps the versions of the tags
+func (c *MemcachedCacher) InvalidateTags(ctx context.Context, tags ...string) error {
+	return c.with(ctx, func(conn *memcachedConn) error {
+		for _, tag := range tags {
+			if err := c.bump(conn, c.tagKey(tag)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close closes the idle connections
+func (c *MemcachedCacher) Close() error {
+	return c.pool.Close()
+}
+
+// exptime returns d in memcached's terms, relative seconds up to 30 days and a Unix timestamp beyond
+func (c *MemcachedCacher) exptime(d time.Duration) int64 {
+	if d > memcachedRelativeTTL {
+		return c.now().Add(d).Unix()
+	}
+	// Rounded up, as zero would never expire
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+func (c *MemcachedCacher) namespaceKey() string {
+	return memcachedKey(c.opts.Prefix + "ns")
+}
+
+func (c *MemcachedCacher) tagKey(tag string) string {
+	return memcachedKey(c.opts.Prefix + "tag:" + tag)
+}
+
+// key returns the key of an entry within the current version of the namespace
+func (c *MemcachedCacher) key(conn *memcachedConn, key string) (string, error) {
+	version, err := c.version(conn, c.namespaceKey())
+	if err != nil {
+		return "", err
+	}
+	return memcachedKey(c.opts.Prefix + version + ":" + key), nil
+}
+
+// version returns the version held by key, which is created when missing
+func (c *MemcachedCacher) version(conn *memcachedConn, key string) (string, error) {
+	for {
+		values, err := conn.get(key)
+		if err != nil {
+			return "", err
+		}
+		if version, ok := values[key]; ok {
+			return string(version), nil
+		}
+		// From the clock, so that a version which was evicted is not reused
+		version := strconv.FormatInt(c.now().UnixNano(), 10)
+		added, err := conn.add(key, []byte(version))
+		if err != nil || added {
+			return version, err
+		}
+	}
+}
+
+func (c *MemcachedCacher) bump(conn *memcachedConn, key string) error {
+	found, err := conn.incr(key)
+	if err != nil || found {
+		return err
+	}
+	// Missing, any new version will do
+	_, err = c.version(conn, key)
+	return err
+}
+
+func (c *MemcachedCacher) with(ctx context.Context, f func(conn *memcachedConn) error) error {
+	conn, err := c.pool.get(ctx)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else {
+		_ = conn.SetDeadline(time.Time{})
+	}
+	err = f(conn)
+	var mcErr MemcachedError
+	// The connection might be left in the middle of a reply, unless the server replied an error
+	c.pool.put(conn, err != nil && !errors.As(err, &mcErr) && !errors.Is(err, ErrInvalidEntry))
+	return err
+}
+
+func (c *MemcachedCacher) dial(ctx context.Context) (*memcachedConn, error) {
+	dialer := net.Dialer{Timeout: c.opts.DialTimeout}
+	nc, err := dialer.DialContext(ctx, "tcp", c.opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	return &memcachedConn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}, nil
+}
+
+// memcachedKey returns key when memcached accepts it, else its bytes are escaped,
+// control characters and spaces being forbidden, and it is hashed when too long.
+// Escaped keys can not be mistaken for hashed ones, which start with '#'
+func memcachedKey(key string) string {
+	var sb strings.Builder
+	for i := 0; i < len(key); i++ {
+		b := key[i]
+		if b <= ' ' || b == 0x7f || b == '%' || b == '#' {
+			sb.WriteString(fmt.Sprintf("%%%02x", b))
+			continue
+		}
+		sb.WriteByte(b)
+	}
+	if sb.Len() <= memcachedMaxKey {
+		return sb.String()
+	}
+	sum := sha256.Sum256([]byte(key))
+	return "#" + hex.EncodeToString(sum[:])
+}
+
+// encodeMemcachedEntry prepends the tags and their versions to the value
+func encodeMemcachedEntry(tags map[string]string, value []byte) []byte {
+	pairs := make([]string, 0, 2*len(tags))
+	for tag, version := range tags {
+		pairs = append(pairs, tag, version)
+	}
+	var buf bytes.Buffer
+	writeStrings(&buf, pairs)
+	buf.Write(value)
+	return buf.Bytes()
+}
+
+func decodeMemcachedEntry(entry []byte) (map[string]string, []byte, error) {
+	r := bytes.NewReader(entry)
+	pairs, err := readStrings(r)
+	if err != nil || len(pairs)%2 != 0 {
+		return nil, nil, fmt.Errorf("%w: malformed memcached entry", ErrInvalidEntry)
+	}
+	tags := make(map[string]string, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		tags[pairs[i]] = pairs[i+1]
+	}
+	return tags, entry[len(entry)-r.Len():], nil
+}
+
+type memcachedConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// get returns the values of the keys which were found
+func (conn *memcachedConn) get(keys ...string) (map[string][]byte, error) {
+	if err := conn.send("get " + strings.Join(keys, " ")); err != nil {
+		return nil, err
+	}
+	values := make(map[string][]byte, len(keys))
+	for {
+		line, err := conn.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "END" {
+			return values, nil
+		}
+		// VALUE <key> <flags> <bytes>
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] != "VALUE" {
+			return nil, fmt.Errorf("memcached: unexpected reply %q", line)
+		}
+		n, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("memcached: unexpected reply %q", line)
+		}
+		value := make([]byte, n+2)
+		if _, err := io.ReadFull(conn.r, value); err != nil {
+			return nil, err
+		}
+		values[fields[1]] = value[:n]
+	}
+}
+
+func (conn *memcachedConn) set(key string, value []byte, exptime int64) error {
+	_, err := conn.store("set", key, value, exptime)
+	return err
+}
+
+// add reports whether the value was stored, as the key was missing
+func (conn *memcachedConn) add(key string, value []byte) (bool, error) {
+	return conn.store("add", key, value, 0)
+}
+
+func (conn *memcachedConn) store(cmd, key string, value []byte, exptime int64) (bool, error) {
+	if _, err := fmt.Fprintf(conn.w, "%s %s 0 %d %d\r\n", cmd, key, exptime, len(value)); err != nil {
+		return false, err
+	}
+	_, _ = conn.w.Write(value)
+	if err := conn.send(""); err != nil {
+		return false, err
+	}
+	line, err := conn.readLine()
+	switch {
+	case err != nil:
+		return false, err
+	case line == "STORED":
+		return true, nil
+	case line == "NOT_STORED":
+		return false, nil
+	}
+	return false, fmt.Errorf("memcached: unexpected reply %q", line)
+}
+
+func (conn *memcachedConn) delete(key string) error {
+	if err := conn.send("delete " + key); err != nil {
+		return err
+	}
+	line, err := conn.readLine()
+	if err != nil || line == "DELETED" || line == "NOT_FOUND" {
+		return err
+	}
+	return fmt.Errorf("memcached: unexpected reply %q", line)
+}
+
+// incr reports whether the key was found
+func (conn *memcachedConn) incr(key string) (bool, error) {
+	if err := conn.send("incr " + key + " 1"); err != nil {
+		return false, err
+	}
+	line, err := conn.readLine()
+	if err != nil || line == "NOT_FOUND" {
+		return false, err
+	}
+	if _, err := strconv.ParseUint(line, 10, 64); err != nil {
+		return false, fmt.Errorf("memcached: unexpected reply %q", line)
+	}
+	return true, nil
+}
+
+func (conn *memcachedConn) send(line string) error {
+	_, _ = conn.w.WriteString(line + "\r\n")
+	return conn.w.Flush()
+}
+
+// readLine reads a line of reply, returning the errors replied as MemcachedError
+func (conn *memcachedConn) readLine() (string, error) {
+	line, err := conn.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "ERROR" || strings.HasPrefix(line, "CLIENT_ERROR") || strings.HasPrefix(line, "SERVER_ERROR") {
+		return "", MemcachedError(line)
+	}
+	return line, nil
+}
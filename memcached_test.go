@@ -0,0 +1,387 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMemcached is a memcached server implementing the commands used by MemcachedCacher
+type fakeMemcached struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	values  map[string][]byte
+	expires map[string]time.Time
+	// exptimes records the exptime of the set commands
+	exptimes map[string]int64
+}
+
+func newFakeMemcached(t *testing.T) *fakeMemcached {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	s := &fakeMemcached{
+		listener: l,
+		values:   make(map[string][]byte),
+		expires:  make(map[string]time.Time),
+		exptimes: make(map[string]int64),
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeMemcached) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeMemcached) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			_, _ = w.WriteString("ERROR\r\n")
+			_ = w.Flush()
+			continue
+		}
+		for _, key := range fields[1:] {
+			if len(key) > memcachedMaxKey {
+				_, _ = w.WriteString("CLIENT_ERROR bad command line format\r\n")
+				_ = w.Flush()
+				return
+			}
+		}
+
+		s.mu.Lock()
+		for key, expires := range s.expires {
+			if !time.Now().Before(expires) {
+				delete(s.values, key)
+				delete(s.expires, key)
+			}
+		}
+		switch fields[0] {
+		case "get":
+			for _, key := range fields[1:] {
+				if val, ok := s.values[key]; ok {
+					_, _ = fmt.Fprintf(w, "VALUE %s 0 %d\r\n%s\r\n", key, len(val), val)
+				}
+			}
+			_, _ = w.WriteString("END\r\n")
+		case "set", "add":
+			exptime, _ := strconv.ParseInt(fields[3], 10, 64)
+			n, _ := strconv.Atoi(fields[4])
+			val := make([]byte, n+2)
+			if _, err := io.ReadFull(r, val); err != nil {
+				s.mu.Unlock()
+				return
+			}
+			key := fields[1]
+			if _, ok := s.values[key]; ok && fields[0] == "add" {
+				_, _ = w.WriteString("NOT_STORED\r\n")
+				break
+			}
+			s.values[key] = val[:n]
+			s.exptimes[key] = exptime
+			delete(s.expires, key)
+			switch {
+			case exptime > int64(memcachedRelativeTTL/time.Second):
+				s.expires[key] = time.Unix(exptime, 0)
+			case exptime > 0:
+				s.expires[key] = time.Now().Add(time.Duration(exptime) * time.Second)
+			}
+			_, _ = w.WriteString("STORED\r\n")
+		case "delete":
+			if _, ok := s.values[fields[1]]; !ok {
+				_, _ = w.WriteString("NOT_FOUND\r\n")
+				break
+			}
+			delete(s.values, fields[1])
+			_, _ = w.WriteString("DELETED\r\n")
+		case "incr":
+			val, ok := s.values[fields[1]]
+			if !ok {
+				_, _ = w.WriteString("NOT_FOUND\r\n")
+				break
+			}
+			n, _ := strconv.ParseUint(string(val), 10, 64)
+			s.values[fields[1]] = []byte(strconv.FormatUint(n+1, 10))
+			_, _ = w.WriteString(strconv.FormatUint(n+1, 10) + "\r\n")
+		default:
+			_, _ = w.WriteString("ERROR\r\n")
+		}
+		s.mu.Unlock()
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// expire makes the entries stored with exptime expire
+func (s *fakeMemcached) expire(exptime int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, e := range s.exptimes {
+		if e == exptime {
+			delete(s.values, key)
+		}
+	}
+}
+
+func (s *fakeMemcached) evict(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+}
+
+func TestMemcachedCacher(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("store and get", func(t *testing.T) {
+		server := newFakeMemcached(t)
+		c := NewMemcachedCacher(MemcachedOptions{Addr: server.Addr(), Prefix: "app:"})
+		defer c.Close()
+
+		if _, ok := lruGet(t, c, "a"); ok {
+			t.Error("a miss was expected")
+		}
+		if err := c.Store(ctx, "a", lruQuery(42)); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if val, ok := lruGet(t, c, "a"); !ok || val != 42 {
+			t.Errorf("expected 42 to be returned, got %d, %t", val, ok)
+		}
+		_ = c.Delete(ctx, "a")
+		if _, ok := lruGet(t, c, "a"); ok {
+			t.Error("the entry was expected to be deleted")
+		}
+	})
+
+	t.Run("keys", func(t *testing.T) {
+		server := newFakeMemcached(t)
+		c := NewMemcachedCacher(MemcachedOptions{Addr: server.Addr()})
+		defer c.Close()
+
+		keys := []string{
+			"SELECT * FROM `users` WHERE name = ?-[s:\"a b\"]",
+			"with\r\nnewlines\tand\x00controls",
+			strings.Repeat("long", 100),
+			strings.Repeat("long", 100) + "er",
+		}
+		for i, key := range keys {
+			if err := c.Store(ctx, key, lruQuery(i)); err != nil {
+				t.Fatalf("an unexpected error has occurred, %v", err)
+			}
+		}
+		for i, key := range keys {
+			if val, ok := lruGet(t, c, key); !ok || val != i {
+				t.Errorf("expected %d to be returned for `%s`, got %d, %t", i, key, val, ok)
+			}
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		server := newFakeMemcached(t)
+		c := NewMemcachedCacher(MemcachedOptions{Addr: server.Addr()})
+		defer c.Close()
+		now := time.Now()
+		c.now = func() time.Time { return now }
+
+		for name, tc := range map[string]struct {
+			d        time.Duration
+			expected int64
+		}{
+			"sub-second": {100 * time.Millisecond, 1},
+			"relative":   {90 * time.Second, 90},
+			"30 days":    {memcachedRelativeTTL, int64(memcachedRelativeTTL / time.Second)},
+			"absolute":   {60 * 24 * time.Hour, now.Add(60 * 24 * time.Hour).Unix()},
+			"never":      {0, 0},
+		} {
+			t.Run(name, func(t *testing.T) {
+				if actual := c.exptime(tc.d); tc.d > 0 && actual != tc.expected {
+					t.Errorf("expected the exptime %d, got %d", tc.expected, actual)
+				}
+			})
+		}
+
+		_ = c.Store(ctx, "short", lruQuery(1), 90*time.Second)
+		_ = c.Store(ctx, "forever", lruQuery(2))
+		server.expire(90)
+		if _, ok := lruGet(t, c, "short"); ok {
+			t.Error("the expired entry was not expected to be returned")
+		}
+		if _, ok := lruGet(t, c, "forever"); !ok {
+			t.Error("the entry without TTL was expected to be kept")
+		}
+	})
+
+	t.Run("invalidation", func(t *testing.T) {
+		server := newFakeMemcached(t)
+		c := NewMemcachedCacher(MemcachedOptions{Addr: server.Addr(), Prefix: "app:"})
+		defer c.Close()
+		other := NewMemcachedCacher(MemcachedOptions{Addr: server.Addr(), Prefix: "other:"})
+		defer other.Close()
+
+		_ = other.Store(ctx, "a", lruQuery(0))
+		_ = c.Store(ctx, "a", lruQuery(1, "tenant:a"))
+		_ = c.Store(ctx, "b", lruQuery(2, "tenant:b"))
+		_ = c.Store(ctx, "c", lruQuery(3))
+
+		if err := c.InvalidateTags(ctx, "tenant:a"); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if _, ok := lruGet(t, c, "a"); ok {
+			t.Error("the tagged entry was expected to be invalidated")
+		}
+		for _, key := range []string{"b", "c"} {
+			if _, ok := lruGet(t, c, key); !ok {
+				t.Errorf("`%s` was expected to be kept", key)
+			}
+		}
+		_ = c.Store(ctx, "a", lruQuery(1, "tenant:a"))
+		if _, ok := lruGet(t, c, "a"); !ok {
+			t.Error("the entry stored after the invalidation was expected to be returned")
+		}
+
+		if err := c.Invalidate(ctx); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		for _, key := range []string{"a", "b", "c"} {
+			if _, ok := lruGet(t, c, key); ok {
+				t.Errorf("`%s` was expected to be invalidated", key)
+			}
+		}
+		if _, ok := lruGet(t, other, "a"); !ok {
+			t.Error("the entries of the other prefix were expected to be kept")
+		}
+	})
+
+	t.Run("evicted version", func(t *testing.T) {
+		server := newFakeMemcached(t)
+		c := NewMemcachedCacher(MemcachedOptions{Addr: server.Addr()})
+		defer c.Close()
+		now := time.Now()
+		c.now = func() time.Time { return now }
+
+		_ = c.Store(ctx, "a", lruQuery(1))
+		server.evict(c.namespaceKey())
+		now = now.Add(time.Second)
+		if _, ok := lruGet(t, c, "a"); ok {
+			t.Error("the entries were expected to be missed once the version of the namespace is evicted")
+		}
+
+		_ = c.Store(ctx, "b", lruQuery(1, "tag"))
+		server.evict(c.tagKey("tag"))
+		now = now.Add(time.Second)
+		if _, ok := lruGet(t, c, "b"); ok {
+			t.Error("the entries were expected to be missed once the version of their tag is evicted")
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		server := newFakeMemcached(t)
+		c := NewMemcachedCacher(MemcachedOptions{Addr: server.Addr(), PoolSize: 1})
+		defer c.Close()
+
+		var mcErr MemcachedError
+		err := c.with(ctx, func(conn *memcachedConn) error {
+			if err := conn.send("unknown"); err != nil {
+				return err
+			}
+			_, err := conn.readLine()
+			return err
+		})
+		if !errors.As(err, &mcErr) {
+			t.Errorf("expected a MemcachedError, got %v", err)
+		}
+		_ = c.Store(ctx, "a", lruQuery(1))
+		if _, ok := lruGet(t, c, "a"); !ok {
+			t.Error("the connection was expected to be usable after an error")
+		}
+
+		closed := NewMemcachedCacher(MemcachedOptions{Addr: "127.0.0.1:1", DialTimeout: 100 * time.Millisecond})
+		if _, err := closed.Get(ctx, "a", &Query[any]{}); err == nil {
+			t.Error("an error was expected, got none")
+		}
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		server := newFakeMemcached(t)
+		c := NewMemcachedCacher(MemcachedOptions{Addr: server.Addr(), PoolSize: 4})
+		defer c.Close()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					key := fmt.Sprintf("key-%d", (i+j)%10)
+					if err := c.Store(ctx, key, lruQuery(j, key)); err != nil {
+						t.Errorf("an unexpected error has occurred, %v", err)
+						return
+					}
+					if j%10 == 0 {
+						_ = c.InvalidateTags(ctx, key)
+					}
+					var dest int
+					if _, err := c.Get(ctx, key, &Query[any]{Dest: &dest}); err != nil {
+						t.Errorf("an unexpected error has occurred, %v", err)
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+	})
+}
+
+func Test_memcachedKey(t *testing.T) {
+	testCases := map[string]string{
+		"plain":        "plain",
+		"a b":          "a%20b",
+		"a%20b":        "a%2520b",
+		"#hash":        "%23hash",
+		"\r\n\x00\x7f": "%0d%0a%00%7f",
+		"ünïcode":      "ünïcode",
+	}
+	for key, expected := range testCases {
+		if actual := memcachedKey(key); actual != expected {
+			t.Errorf("memcachedKey expected to return `%s` for %q, got `%s`", expected, key, actual)
+		}
+	}
+
+	long := memcachedKey(strings.Repeat("a", 251))
+	if len(long) > memcachedMaxKey || !strings.HasPrefix(long, "#") {
+		t.Errorf("expected long keys to be hashed, got `%s`", long)
+	}
+	if memcachedKey(strings.Repeat("a", 250)) != strings.Repeat("a", 250) {
+		t.Error("expected keys of 250 bytes to be kept")
+	}
+	if memcachedKey(strings.Repeat(" ", 100)) == memcachedKey(strings.Repeat(" ", 101)) {
+		t.Error("expected different keys to stay different once hashed")
+	}
+}
@@ -0,0 +1,207 @@
+package cache
+
+import (
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+)
+
+// Normalizer canonicalizes the SQL and the arguments a query is identified by,
+// so that equivalent queries share their entries, look at Config.Normalizer.
+// It must never make different queries look the same
+type Normalizer func(db *gorm.DB, sql string, vars []any) (string, []any)
+
+var (
+	// NormalizeWhitespace collapses the runs of whitespace outside of quotes, which is the default,
+	// the SQL is left as it is when it has comments, escapes or dollar quotes it can not safely tell apart
+	NormalizeWhitespace Normalizer = func(_ *gorm.DB, sql string, vars []any) (string, []any) {
+		return collapseWhitespace(sql), vars
+	}
+	// NormalizeClauses sorts the AND conditions of the WHERE clause and the values of IN conditions,
+	// as long as the WHERE clause has no OR condition, then collapses whitespace
+	NormalizeClauses Normalizer = func(db *gorm.DB, sql string, vars []any) (string, []any) {
+		sql, vars = sortClauses(db, sql, vars)
+		return collapseWhitespace(sql), vars
+	}
+)
+
+var (
+	// unsafeSQLRe matches what changes how quotes are read, comments, backslash escapes and dollar quotes
+	unsafeSQLRe = regexp.MustCompile(`--|/\*|#|\\|\$[A-Za-z_]*\$`)
+	// inRe matches conditions such as "id IN ?", "id NOT IN (?)"
+	inRe = regexp.MustCompile("(?i)^\\s*[`\"\\w.]+\\s+(?:NOT\\s+)?IN\\s*(?:\\(\\s*\\?\\s*\\)|\\?)\\s*$")
+)
+
+// normalize builds the SQL of the query and replaces it, along with its arguments, by their normalized form,
+// the returned func restores them so that the query is executed as it was built
+func normalize(db *gorm.DB, normalizer Normalizer) (restore func()) {
+	callbacks.BuildQuerySQL(db)
+	sql, vars := db.Statement.SQL.String(), db.Statement.Vars
+	normalized, normalizedVars := normalizer(db, sql, vars)
+	if normalized == sql && reflect.DeepEqual(normalizedVars, vars) {
+		return func() {}
+	}
+
+	db.Statement.SQL.Reset()
+	db.Statement.SQL.WriteString(normalized)
+	db.Statement.Vars = normalizedVars
+	return func() {
+		db.Statement.SQL.Reset()
+		db.Statement.SQL.WriteString(sql)
+		db.Statement.Vars = vars
+	}
+}
+
+func collapseWhitespace(sql string) string {
+	if unsafeSQLRe.MatchString(sql) {
+		return sql
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(sql))
+	var quote rune
+	space := false
+	for _, r := range strings.TrimSpace(sql) {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f' || r == '\v':
+			space = true
+			continue
+		}
+		if space {
+			sb.WriteByte(' ')
+			space = false
+		}
+		sb.WriteRune(r)
+	}
+	if quote != 0 {
+		// Unbalanced quotes, better left alone
+		return sql
+	}
+	return sb.String()
+}
+
+// sortClauses renders the clauses of the query with its WHERE clause sorted,
+// unless the SQL was not built out of them, as for Raw queries
+func sortClauses(db *gorm.DB, sql string, vars []any) (string, []any) {
+	c, ok := db.Statement.Clauses["WHERE"]
+	if !ok {
+		return sql, vars
+	}
+	where, ok := c.Expression.(clause.Where)
+	if !ok {
+		return sql, vars
+	}
+	if built, _ := renderClauses(db, db.Statement.Clauses); built != sql {
+		return sql, vars
+	}
+
+	exprs := where.Exprs
+	if len(exprs) == 1 {
+		if and, ok := exprs[0].(clause.AndConditions); ok {
+			exprs = and.Exprs
+		}
+	}
+	sorted := make([]clause.Expression, len(exprs))
+	keys := make([]string, len(exprs))
+	for i, expr := range exprs {
+		if _, ok := expr.(clause.OrConditions); ok {
+			// Whether conditions are AND'd or OR'd depends on their order
+			return sql, vars
+		}
+		sorted[i] = sortValues(expr)
+		keys[i] = renderExpression(db, sorted[i])
+	}
+	order := make([]int, len(sorted))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return keys[order[i]] < keys[order[j]] })
+	res := make([]clause.Expression, len(sorted))
+	for i, idx := range order {
+		res[i] = sorted[idx]
+	}
+
+	clauses := make(map[string]clause.Clause, len(db.Statement.Clauses))
+	for name, cl := range db.Statement.Clauses {
+		clauses[name] = cl
+	}
+	c.Expression = clause.Where{Exprs: res}
+	clauses["WHERE"] = c
+	return renderClauses(db, clauses)
+}
+
+// sortValues returns the IN conditions with their values sorted
+func sortValues(expr clause.Expression) clause.Expression {
+	switch e := expr.(type) {
+	case clause.IN:
+		e.Values = sortedValues(e.Values)
+		return e
+	case clause.Expr:
+		if len(e.Vars) != 1 || !inRe.MatchString(e.SQL) {
+			return e
+		}
+		v := reflect.ValueOf(e.Vars[0])
+		if v.Kind() != reflect.Slice || v.Type().Elem().Kind() == reflect.Uint8 {
+			return e
+		}
+		values := make([]any, v.Len())
+		for i := range values {
+			values[i] = v.Index(i).Interface()
+		}
+		e.Vars = []any{sortedValues(values)}
+		return e
+	}
+	return expr
+}
+
+func sortedValues(values []any) []any {
+	keys := make([]string, len(values))
+	order := make([]int, len(values))
+	for i, v := range values {
+		keys[i] = valueToString(v)
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return keys[order[i]] < keys[order[j]] })
+	res := make([]any, len(values))
+	for i, idx := range order {
+		res[i] = values[idx]
+	}
+	return res
+}
+
+func renderExpression(db *gorm.DB, expr clause.Expression) string {
+	stmt := scratchStatement(db, nil)
+	expr.Build(stmt)
+	return stmt.SQL.String() + valueToString(stmt.Vars)
+}
+
+func renderClauses(db *gorm.DB, clauses map[string]clause.Clause) (string, []any) {
+	stmt := scratchStatement(db, clauses)
+	stmt.Build(db.Statement.BuildClauses...)
+	return stmt.SQL.String(), stmt.Vars
+}
+
+// scratchStatement returns a statement to render clauses with, as the query's would
+func scratchStatement(db *gorm.DB, clauses map[string]clause.Clause) *gorm.Statement {
+	return &gorm.Statement{
+		DB:        db,
+		ConnPool:  db.Statement.ConnPool,
+		Context:   db.Statement.Context,
+		Table:     db.Statement.Table,
+		TableExpr: db.Statement.TableExpr,
+		Schema:    db.Statement.Schema,
+		Model:     db.Statement.Model,
+		Clauses:   clauses,
+	}
+}
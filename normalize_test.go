@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/utils/tests"
+)
+
+func Test_collapseWhitespace(t *testing.T) {
+	testCases := map[string]struct {
+		sql      string
+		expected string
+	}{
+		"spaces":          {"  SELECT *\n\tFROM   users  WHERE id = ? ", "SELECT * FROM users WHERE id = ?"},
+		"quoted":          {"SELECT * FROM users WHERE name = 'a   b'  AND `my  col` = \"x  y\"", "SELECT * FROM users WHERE name = 'a   b' AND `my  col` = \"x  y\""},
+		"doubled quotes":  {"SELECT 'it''s   here'   FROM users", "SELECT 'it''s   here' FROM users"},
+		"line comment":    {"SELECT * -- users\n  FROM users", "SELECT * -- users\n  FROM users"},
+		"block comment":   {"SELECT /* a  b */  1", "SELECT /* a  b */  1"},
+		"backslash":       {"SELECT 'a\\'  b'  FROM users", "SELECT 'a\\'  b'  FROM users"},
+		"dollar quotes":   {"SELECT $$a   b$$  AS x", "SELECT $$a   b$$  AS x"},
+		"unbalanced":      {"SELECT 'a  b", "SELECT 'a  b"},
+		"bind variables":  {"SELECT *  FROM users WHERE id = $1", "SELECT * FROM users WHERE id = $1"},
+		"already compact": {"SELECT * FROM users", "SELECT * FROM users"},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if actual := collapseWhitespace(tc.sql); actual != tc.expected {
+				t.Errorf("collapseWhitespace expected to return `%s` but got `%s`", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestNormalizer(t *testing.T) {
+	identify := func(t *testing.T, normalizer Normalizer, query func(db *gorm.DB) *gorm.DB) string {
+		cacher := &cacherMock{}
+		db := openCachedDB(t, &Config{Cacher: cacher, Normalizer: normalizer})
+		tx := query(db)
+		if tx.Error != nil {
+			t.Fatalf("an unexpected error has occurred, %v", tx.Error)
+		}
+		var key string
+		cacher.store.Range(func(k, _ any) bool {
+			key = k.(string)
+			return false
+		})
+		return key
+	}
+
+	testCases := map[string]struct {
+		normalizer Normalizer
+		a, b       func(db *gorm.DB) *gorm.DB
+		same       bool
+	}{
+		"raw whitespace": {
+			a: func(db *gorm.DB) *gorm.DB {
+				return db.Raw("SELECT *  FROM users\n WHERE id = ?", 1).Find(&[]tests.User{})
+			},
+			b: func(db *gorm.DB) *gorm.DB {
+				return db.Raw("SELECT * FROM users WHERE id = ?", 1).Find(&[]tests.User{})
+			},
+			same: true,
+		},
+		"quoted whitespace": {
+			a: func(db *gorm.DB) *gorm.DB {
+				return db.Raw("SELECT * FROM users WHERE name = 'a  b'").Find(&[]tests.User{})
+			},
+			b: func(db *gorm.DB) *gorm.DB {
+				return db.Raw("SELECT * FROM users WHERE name = 'a b'").Find(&[]tests.User{})
+			},
+		},
+		"where order by default": {
+			a: func(db *gorm.DB) *gorm.DB {
+				return db.Where("name = ?", "a").Where("age = ?", 1).Find(&[]tests.User{})
+			},
+			b: func(db *gorm.DB) *gorm.DB {
+				return db.Where("age = ?", 1).Where("name = ?", "a").Find(&[]tests.User{})
+			},
+		},
+		"where order": {
+			normalizer: NormalizeClauses,
+			a: func(db *gorm.DB) *gorm.DB {
+				return db.Where("name = ?", "a").Where("age = ?", 1).Find(&[]tests.User{})
+			},
+			b: func(db *gorm.DB) *gorm.DB {
+				return db.Where("age = ?", 1).Where("name = ?", "a").Find(&[]tests.User{})
+			},
+			same: true,
+		},
+		"not in": {
+			normalizer: NormalizeClauses,
+			a: func(db *gorm.DB) *gorm.DB {
+				return db.Where("id IN ?", []int{3, 1, 2}).Find(&[]tests.User{})
+			},
+			b: func(db *gorm.DB) *gorm.DB {
+				return db.Where("id NOT IN (?)", []int{1, 2, 3}).Find(&[]tests.User{})
+			},
+		},
+		"in list": {
+			normalizer: NormalizeClauses,
+			a: func(db *gorm.DB) *gorm.DB {
+				return db.Where("id IN ?", []int{3, 1, 2}).Find(&[]tests.User{})
+			},
+			b: func(db *gorm.DB) *gorm.DB {
+				return db.Where("id IN ?", []int{1, 2, 3}).Find(&[]tests.User{})
+			},
+			same: true,
+		},
+		"in clause": {
+			normalizer: NormalizeClauses,
+			a: func(db *gorm.DB) *gorm.DB {
+				return db.Clauses(clause.IN{Column: "id", Values: []any{"b", "a"}}).Find(&[]tests.User{})
+			},
+			b: func(db *gorm.DB) *gorm.DB {
+				return db.Clauses(clause.IN{Column: "id", Values: []any{"a", "b"}}).Find(&[]tests.User{})
+			},
+			same: true,
+		},
+		"different values": {
+			normalizer: NormalizeClauses,
+			a: func(db *gorm.DB) *gorm.DB {
+				return db.Where("name = ?", "a").Where("age = ?", 1).Find(&[]tests.User{})
+			},
+			b: func(db *gorm.DB) *gorm.DB {
+				return db.Where("age = ?", "a").Where("name = ?", 1).Find(&[]tests.User{})
+			},
+		},
+		"or": {
+			normalizer: NormalizeClauses,
+			a: func(db *gorm.DB) *gorm.DB {
+				return db.Where("name = ?", "a").Where("age = ?", 1).Or("id = ?", 2).Find(&[]tests.User{})
+			},
+			b: func(db *gorm.DB) *gorm.DB {
+				return db.Where("age = ?", 1).Where("name = ?", "a").Or("id = ?", 2).Find(&[]tests.User{})
+			},
+		},
+		"order by": {
+			normalizer: NormalizeClauses,
+			a: func(db *gorm.DB) *gorm.DB {
+				return db.Order("name").Order("age").Find(&[]tests.User{})
+			},
+			b: func(db *gorm.DB) *gorm.DB {
+				return db.Order("age").Order("name").Find(&[]tests.User{})
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			a := identify(t, tc.normalizer, tc.a)
+			b := identify(t, tc.normalizer, tc.b)
+			if a == "" || b == "" {
+				t.Fatalf("the results were expected to be stored, got `%s` and `%s`", a, b)
+			}
+			if (a == b) != tc.same {
+				t.Errorf("identifiers expected to be the same: %t, got `%s` and `%s`", tc.same, a, b)
+			}
+		})
+	}
+
+	t.Run("executed as built", func(t *testing.T) {
+		var sql, vars string
+		identify(t, NormalizeClauses, func(db *gorm.DB) *gorm.DB {
+			// The statement is reset once executed
+			_ = db.Callback().Query().After("gorm:query").Register("test:executed", func(db *gorm.DB) {
+				sql, vars = db.Statement.SQL.String(), valueToString(db.Statement.Vars)
+			})
+			return db.Where("name = ?", "a").Where("id IN ?", []int{3, 1, 2}).Find(&[]tests.User{})
+		})
+		expected := "SELECT * FROM `users` WHERE name = ? AND id IN (?,?,?) AND `users`.`deleted_at` IS NULL"
+		if sql != expected {
+			t.Errorf("the executed query was expected to be `%s`, got `%s`", expected, sql)
+		}
+		if actual := vars; actual != `[s:"a",i:3,i:1,i:2]` {
+			t.Errorf("the arguments were expected to be left as they are, got %s", actual)
+		}
+	})
+}
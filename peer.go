@@ -0,0 +1,429 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PeerOptions configures a PeerCacher
+type PeerOptions struct {
+	// Self is the base URL of this process, such as http://10.0.0.1:8080, which must be one of the Peers
+	Self string
+	// Peers are the base URLs of the processes sharing the cache, Self included
+	Peers []string
+	// BasePath is where the PeerCacher is mounted on the servers, /_gorm_cache/ by default
+	BasePath string
+	// Local keeps the entries owned by this process, a LRUCacher of 10000 entries by default
+	Local Cacher
+	// Replicas is the number of points of each peer on the hash ring, 50 by default
+	Replicas int
+	// LoadTimeout bounds how long the lookups of a key wait for the one loading it, 2s by default
+	LoadTimeout time.Duration
+	// Client sends the requests to the other peers, one with a timeout of 10s by default
+	Client *http.Client
+	// Authorize authenticates the requests of the other peers, which are replied 403 when it returns false.
+	// It is required, every request being replied 403 without it. The credentials it checks
+	// can be sent by the Transport of Client, such as a shared token or a client certificate
+	Authorize func(r *http.Request) bool
+}
+
+// PeerCacher is a Cacher sharing its entries among processes, each one owning the keys
+// which are closest to it on a consistent hash ring of the peers, the other ones reading
+// and storing them over HTTP. When a key is missed, the following lookups of it wait for
+// the query loading it to store it, or to release it, so that it is loaded once whichever process asks for it.
+// The PeerCacher must be served by every process, under BasePath.
+//
+// The peers trust each other: whoever is authorized can read, store, delete and invalidate the entries,
+// that is serve any result to the queries of every process. ServeHTTP therefore refuses the requests
+// unless PeerOptions.Authorize accepts them, the endpoints should moreover only be reachable by the peers
+type PeerCacher struct {
+	opts PeerOptions
+
+	ringMu sync.RWMutex
+	ring   *hashRing
+
+	mu sync.Mutex
+	// loads holds the keys missed by a lookup, until they are stored or released
+	loads map[string]*peerLoad
+
+	now func() time.Time
+	janitor
+}
+
+type peerLoad struct {
+	done     chan struct{}
+	deadline time.Time
+}
+
+// NewPeerCacher returns a PeerCacher, Close stops the janitor dropping the abandoned loads
+func NewPeerCacher(opts PeerOptions) *PeerCacher {
+	if opts.BasePath == "" {
+		opts.BasePath = "/_gorm_cache/"
+	}
+	if !strings.HasSuffix(opts.BasePath, "/") {
+		opts.BasePath += "/"
+	}
+	if opts.Local == nil {
+		opts.Local = newLRUCacher(10000)
+	}
+	if opts.Replicas <= 0 {
+		opts.Replicas = 50
+	}
+	if opts.LoadTimeout <= 0 {
+		opts.LoadTimeout = 2 * time.Second
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	c := &PeerCacher{
+		opts:    opts,
+		ring:    newHashRing(opts.Replicas, opts.Peers),
+		loads:   make(map[string]*peerLoad),
+		now:     time.Now,
+		janitor: newJanitor(),
+	}
+	c.janitor.start(opts.LoadTimeout, c.dropLoads)
+	return c
+}
+
+// SetPeers replaces the peers, only the keys of the peers which are added or removed move
+func (c *PeerCacher) SetPeers(peers ...string) {
+	ring := newHashRing(c.opts.Replicas, peers)
+	c.ringMu.Lock()
+	defer c.ringMu.Unlock()
+	c.opts.Peers = peers
+	c.ring = ring
+}
+
+func (c *PeerCacher) Get(ctx context.Context, key string, q *Query[any]) (*Query[any], error) {
+	var value []byte
+	var err error
+	if peer := c.owner(key); peer == c.opts.Self {
+		value, err = c.lookup(ctx, key)
+	} else {
+		value, err = c.fetch(ctx, peer, key)
+	}
+	if err != nil || value == nil {
+		return nil, err
+	}
+	if err := q.Unmarshal(value); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Store stores val on the owner of key, releasing the lookups waiting for it
+func (c *PeerCacher) Store(ctx context.Context, key string, val *Query[any], d ...time.Duration) error {
+	value, err := val.Marshal()
+	if err != nil {
+		return err
+	}
+	var ttl time.Duration
+	if len(d) > 0 && d[0] > 0 {
+		ttl = d[0]
+	}
+	meta := val.Metadata()
+	if peer := c.owner(key); peer != c.opts.Self {
+		params := url.Values{"key": {key}, "ttl": {strconv.FormatInt(int64(ttl), 10)}, "table": meta.Tables, "tag": meta.Tags}
+		_, err := c.send(ctx, peer, http.MethodPut, "entry", params, value)
+		return err
+	}
+	return c.storeLocal(ctx, key, value, meta.Tables, meta.Tags, ttl)
+}
+
+// Invalidate invalidates the entries of every peer
+func (c *PeerCacher) Invalidate(ctx context.Context) error {
+	return c.broadcast(ctx, nil)
+}
+
+// InvalidateTags invalidates the tags on every peer
+func (c *PeerCacher) InvalidateTags(ctx context.Context, tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	return c.broadcast(ctx, tags)
+}
+
+// Release releases the lookups waiting for key to be stored, which are then missed
+func (c *PeerCacher) Release(ctx context.Context, key string) error {
+	if peer := c.owner(key); peer != c.opts.Self {
+		_, err := c.send(ctx, peer, http.MethodPost, "release", url.Values{"key": {key}}, nil)
+		return err
+	}
+	c.releaseLocal(key)
+	return nil
+}
+
+func (c *PeerCacher) Delete(ctx context.Context, key string) error {
+	if peer := c.owner(key); peer != c.opts.Self {
+		_, err := c.send(ctx, peer, http.MethodDelete, "entry", url.Values{"key": {key}}, nil)
+		return err
+	}
+	return c.deleteLocal(ctx, key)
+}
+
+// ServeHTTP serves the requests of the other peers, for the keys this process owns
+func (c *PeerCacher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if c.opts.Authorize == nil || !c.opts.Authorize(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	ctx := r.Context()
+	params := r.URL.Query()
+	key := params.Get("key")
+
+	var err error
+	switch route := strings.TrimPrefix(r.URL.Path, c.opts.BasePath); {
+	case route == "entry" && r.Method == http.MethodGet:
+		var value []byte
+		if value, err = c.lookup(ctx, key); err == nil {
+			if value == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, _ = w.Write(value)
+			return
+		}
+	case route == "entry" && r.Method == http.MethodPut:
+		var value []byte
+		var ttl int64
+		if value, err = io.ReadAll(r.Body); err == nil {
+			if ttl, err = strconv.ParseInt(params.Get("ttl"), 10, 64); err != nil {
+				http.Error(w, "invalid ttl", http.StatusBadRequest)
+				return
+			}
+			err = c.storeLocal(ctx, key, value, params["table"], params["tag"], time.Duration(ttl))
+		}
+	case route == "entry" && r.Method == http.MethodDelete:
+		err = c.deleteLocal(ctx, key)
+	case route == "release" && r.Method == http.MethodPost:
+		c.releaseLocal(key)
+	case route == "invalidate" && r.Method == http.MethodPost:
+		err = c.invalidateLocal(ctx, params["tag"])
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// owner returns the peer owning key, this one when there is none
+func (c *PeerCacher) owner(key string) string {
+	c.ringMu.RLock()
+	defer c.ringMu.RUnlock()
+	if peer := c.ring.owner(key); peer != "" {
+		return peer
+	}
+	return c.opts.Self
+}
+
+// lookup reads an owned key. When it is missed, the caller is expected to load and store or release it,
+// while the other lookups of the key wait for it, until LoadTimeout
+func (c *PeerCacher) lookup(ctx context.Context, key string) ([]byte, error) {
+	if value, err := c.getLocal(ctx, key); err != nil || value != nil {
+		return value, err
+	}
+
+	c.mu.Lock()
+	// Stored in the meantime
+	if value, err := c.getLocal(ctx, key); err != nil || value != nil {
+		c.mu.Unlock()
+		return value, err
+	}
+	now := c.now()
+	load, ok := c.loads[key]
+	if !ok || !now.Before(load.deadline) {
+		// Abandoned loads are taken over
+		c.loads[key] = &peerLoad{done: make(chan struct{}), deadline: now.Add(c.opts.LoadTimeout)}
+		c.mu.Unlock()
+		return nil, nil
+	}
+	c.mu.Unlock()
+
+	timer := time.NewTimer(load.deadline.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-load.done:
+		return c.getLocal(ctx, key)
+	case <-timer.C:
+		// Loaded by this lookup too, it will not be waited for anyway
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *PeerCacher) getLocal(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	res, err := c.opts.Local.Get(ctx, key, &Query[any]{codec: rawCodec{&value}})
+	if err != nil || res == nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (c *PeerCacher) storeLocal(ctx context.Context, key string, value []byte, tables, tags []string, ttl time.Duration) error {
+	q := &Query[any]{codec: rawCodec{&value}, meta: Metadata{Tables: tables, Tags: tags}}
+	var d []time.Duration
+	if ttl > 0 {
+		d = append(d, ttl)
+	}
+	err := c.opts.Local.Store(ctx, key, q, d...)
+	c.releaseLocal(key)
+	return err
+}
+
+// releaseLocal releases the lookups waiting for key, which read it again
+func (c *PeerCacher) releaseLocal(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if load, ok := c.loads[key]; ok {
+		close(load.done)
+		delete(c.loads, key)
+	}
+}
+
+func (c *PeerCacher) deleteLocal(ctx context.Context, key string) error {
+	if deleter, ok := c.opts.Local.(Deleter); ok {
+		return deleter.Delete(ctx, key)
+	}
+	return nil
+}
+
+// invalidateLocal invalidates the tags, or everything when there is none
+// or when the local Cacher is not a TagInvalidator
+func (c *PeerCacher) invalidateLocal(ctx context.Context, tags []string) error {
+	if invalidator, ok := c.opts.Local.(TagInvalidator); ok && len(tags) > 0 {
+		return invalidator.InvalidateTags(ctx, tags...)
+	}
+	return c.opts.Local.Invalidate(ctx)
+}
+
+// broadcast invalidates the tags on every peer, this one included
+func (c *PeerCacher) broadcast(ctx context.Context, tags []string) error {
+	c.ringMu.RLock()
+	peers := slices.Clone(c.opts.Peers)
+	c.ringMu.RUnlock()
+
+	errs := make([]error, len(peers))
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		if peer == c.opts.Self {
+			errs[i] = c.invalidateLocal(ctx, tags)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = c.send(ctx, peer, http.MethodPost, "invalidate", url.Values{"tag": tags}, nil)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// fetch reads an entry from its owner, nil when it is missed
+func (c *PeerCacher) fetch(ctx context.Context, peer, key string) ([]byte, error) {
+	return c.send(ctx, peer, http.MethodGet, "entry", url.Values{"key": {key}}, nil)
+}
+
+// send returns the body of the reply, nil when it is 404
+func (c *PeerCacher) send(ctx context.Context, peer, method, route string, params url.Values, body []byte) ([]byte, error) {
+	u := strings.TrimSuffix(peer, "/") + c.opts.BasePath + route + "?" + params.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	reply, err := io.ReadAll(resp.Body)
+	switch {
+	case err != nil:
+		return nil, err
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, nil
+	case resp.StatusCode >= 300:
+		return nil, fmt.Errorf("cache: peer %s replied %s, %s", peer, resp.Status, bytes.TrimSpace(reply))
+	}
+	return reply, nil
+}
+
+// dropLoads drops the loads which were abandoned, the ones left by the lookups which neither stored nor released their key
+func (c *PeerCacher) dropLoads() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for key, load := range c.loads {
+		if !now.Before(load.deadline) {
+			delete(c.loads, key)
+		}
+	}
+}
+
+// rawCodec passes the entries through, they are serialized by the PeerCacher which stored them
+type rawCodec struct {
+	value *[]byte
+}
+
+func (c rawCodec) Marshal(*Query[any]) ([]byte, error) {
+	return *c.value, nil
+}
+
+func (c rawCodec) Unmarshal(data []byte, _ *Query[any]) error {
+	*c.value = bytes.Clone(data)
+	return nil
+}
+
+// hashRing maps the keys to the peers by consistent hashing, with replicas points by peer
+type hashRing struct {
+	hashes []uint32
+	peers  map[uint32]string
+}
+
+func newHashRing(replicas int, peers []string) *hashRing {
+	r := &hashRing{peers: make(map[uint32]string, replicas*len(peers))}
+	for _, peer := range peers {
+		for i := 0; i < replicas; i++ {
+			hash := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + peer))
+			r.hashes = append(r.hashes, hash)
+			r.peers[hash] = peer
+		}
+	}
+	slices.Sort(r.hashes)
+	return r
+}
+
+// owner returns the peer of the first point following the hash of key, "" when there is no peer
+func (r *hashRing) owner(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	hash := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= hash })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.peers[r.hashes[i]]
+}
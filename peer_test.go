@@ -0,0 +1,317 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newPeers starts n PeerCachers on localhost, each one with its own LRUCacher
+func newPeers(t *testing.T, n int, opts PeerOptions) ([]*PeerCacher, []*LRUCacher) {
+	t.Helper()
+	servers := make([]*httptest.Server, n)
+	handlers := make([]http.Handler, n)
+	peers := make([]string, n)
+	for i := range servers {
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlers[i].ServeHTTP(w, r)
+		}))
+		t.Cleanup(servers[i].Close)
+		peers[i] = servers[i].URL
+	}
+
+	caches := make([]*PeerCacher, n)
+	locals := make([]*LRUCacher, n)
+	for i := range caches {
+		o := opts
+		o.Self, o.Peers = peers[i], peers
+		if o.Authorize == nil {
+			o.Authorize = func(*http.Request) bool { return true }
+		}
+		locals[i] = newLRUCacher(0)
+		o.Local = locals[i]
+		caches[i] = NewPeerCacher(o)
+		handlers[i] = caches[i]
+		t.Cleanup(func() { _ = caches[i].Close() })
+	}
+	return caches, locals
+}
+
+func TestHashRing(t *testing.T) {
+	peers := []string{"http://a", "http://b", "http://c"}
+	ring := newHashRing(50, peers)
+	if owner := newHashRing(50, nil).owner("key"); owner != "" {
+		t.Errorf("expected no owner without peers, got %s", owner)
+	}
+
+	counts := map[string]int{}
+	owners := map[string]string{}
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		owners[key] = ring.owner(key)
+		counts[owners[key]]++
+	}
+	for _, peer := range peers {
+		if counts[peer] < 500 {
+			t.Errorf("expected the keys to be spread over the peers, got %v", counts)
+			break
+		}
+	}
+
+	// Only the keys of the removed peer move
+	ring = newHashRing(50, peers[:2])
+	for key, owner := range owners {
+		if owner != "http://c" && ring.owner(key) != owner {
+			t.Errorf("`%s` was not expected to move from %s to %s", key, owner, ring.owner(key))
+		}
+	}
+}
+
+func TestPeerCacher(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("store and get", func(t *testing.T) {
+		caches, locals := newPeers(t, 3, PeerOptions{})
+		for i := 0; i < 30; i++ {
+			key := fmt.Sprintf("key-%d", i)
+			if err := caches[i%3].Store(ctx, key, lruQuery(i)); err != nil {
+				t.Fatalf("an unexpected error has occurred, %v", err)
+			}
+		}
+
+		total := 0
+		for i, local := range locals {
+			if local.Len() == 0 {
+				t.Errorf("expected peer %d to own entries", i)
+			}
+			total += local.Len()
+		}
+		if total != 30 {
+			t.Errorf("expected each entry to be kept by its owner only, got %d entries", total)
+		}
+
+		for i := 0; i < 30; i++ {
+			key := fmt.Sprintf("key-%d", i)
+			for _, c := range caches {
+				if val, ok := lruGet(t, c, key); !ok || val != i {
+					t.Errorf("expected %d to be returned for `%s`, got %d, %t", i, key, val, ok)
+				}
+			}
+		}
+
+		_ = caches[0].Delete(ctx, "key-1")
+		if _, ok := lruGet(t, caches[2], "key-1"); ok {
+			t.Error("the entry was expected to be deleted")
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		caches, locals := newPeers(t, 2, PeerOptions{})
+		now := time.Now()
+		for _, local := range locals {
+			local.now = func() time.Time { return now }
+		}
+		for i := 0; i < 10; i++ {
+			_ = caches[i%2].Store(ctx, fmt.Sprintf("key-%d", i), lruQuery(i), time.Second)
+		}
+		if _, ok := lruGet(t, caches[0], "key-1"); !ok {
+			t.Error("the entry was expected to be returned before its expiry")
+		}
+		now = now.Add(time.Minute)
+		for i := 0; i < 10; i++ {
+			if _, ok := lruGet(t, caches[0], fmt.Sprintf("key-%d", i)); ok {
+				t.Error("the expired entry was not expected to be returned")
+			}
+		}
+	})
+
+	t.Run("invalidation", func(t *testing.T) {
+		caches, locals := newPeers(t, 3, PeerOptions{})
+		for i := 0; i < 30; i++ {
+			_ = caches[0].Store(ctx, fmt.Sprintf("key-%d", i), lruQuery(i, fmt.Sprintf("tag-%d", i%2)))
+		}
+
+		if err := caches[1].InvalidateTags(ctx, "tag-0"); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		total := 0
+		for _, local := range locals {
+			total += local.Len()
+		}
+		if total != 15 {
+			t.Errorf("expected the tagged entries to be invalidated on every peer, got %d entries", total)
+		}
+
+		if err := caches[2].Invalidate(ctx); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		for i, local := range locals {
+			if local.Len() != 0 {
+				t.Errorf("expected the entries of peer %d to be invalidated, got %d", i, local.Len())
+			}
+		}
+	})
+
+	t.Run("coalescing", func(t *testing.T) {
+		caches, _ := newPeers(t, 3, PeerOptions{LoadTimeout: 5 * time.Second})
+		var loads int32
+		var wg sync.WaitGroup
+		for i := 0; i < 30; i++ {
+			wg.Add(1)
+			go func(c *PeerCacher) {
+				defer wg.Done()
+				var dest int
+				res, err := c.Get(ctx, "key", &Query[any]{Dest: &dest})
+				if err != nil {
+					t.Errorf("an unexpected error has occurred, %v", err)
+					return
+				}
+				if res == nil {
+					// Loaded from the database
+					atomic.AddInt32(&loads, 1)
+					time.Sleep(50 * time.Millisecond)
+					_ = c.Store(ctx, "key", lruQuery(42))
+					return
+				}
+				if dest != 42 {
+					t.Errorf("expected 42 to be returned, got %d", dest)
+				}
+			}(caches[i%3])
+		}
+		wg.Wait()
+		if loads != 1 {
+			t.Errorf("expected the key to be loaded once, got %d", loads)
+		}
+	})
+
+	t.Run("abandoned load", func(t *testing.T) {
+		caches, _ := newPeers(t, 2, PeerOptions{LoadTimeout: 50 * time.Millisecond})
+		if _, ok := lruGet(t, caches[0], "key"); ok {
+			t.Error("a miss was expected")
+		}
+		// Not stored, the following lookup waits until LoadTimeout
+		start := time.Now()
+		if _, ok := lruGet(t, caches[1], "key"); ok {
+			t.Error("a miss was expected")
+		}
+		if elapsed := time.Since(start); elapsed < 40*time.Millisecond || elapsed > time.Second {
+			t.Errorf("expected the lookup to wait for the load until LoadTimeout, waited %s", elapsed)
+		}
+
+		// Taken over
+		if _, ok := lruGet(t, caches[0], "key"); ok {
+			t.Error("a miss was expected")
+		}
+		_ = caches[0].Store(ctx, "key", lruQuery(1))
+		if val, ok := lruGet(t, caches[1], "key"); !ok || val != 1 {
+			t.Errorf("expected 1 to be returned, got %d, %t", val, ok)
+		}
+	})
+
+	t.Run("released load", func(t *testing.T) {
+		caches, _ := newPeers(t, 2, PeerOptions{LoadTimeout: 5 * time.Second})
+		var keys []string
+		for i := 0; len(keys) < 2; i++ {
+			if key := fmt.Sprintf("key-%d", i); caches[0].owner(key) == caches[0].opts.Self {
+				keys = append(keys, key)
+			}
+		}
+		// Released by the owner, then by another peer
+		for i, c := range caches {
+			key := keys[i]
+			if _, ok := lruGet(t, c, key); ok {
+				t.Error("a miss was expected")
+			}
+			// Not stored, because the query failed for instance
+			if err := c.Release(ctx, key); err != nil {
+				t.Fatalf("an unexpected error has occurred, %v", err)
+			}
+			start := time.Now()
+			if _, ok := lruGet(t, caches[1-i], key); ok {
+				t.Error("a miss was expected")
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("expected the lookup not to wait for the released load, waited %s", elapsed)
+			}
+		}
+	})
+
+	t.Run("authorization", func(t *testing.T) {
+		authorize := func(r *http.Request) bool { return r.Header.Get("Authorization") == "Bearer secret" }
+		caches, locals := newPeers(t, 2, PeerOptions{
+			Authorize: authorize,
+			Client:    &http.Client{Transport: bearerTransport("secret")},
+		})
+		for i := 0; i < 10; i++ {
+			if err := caches[0].Store(ctx, fmt.Sprintf("key-%d", i), lruQuery(i)); err != nil {
+				t.Fatalf("an unexpected error has occurred, %v", err)
+			}
+		}
+		if locals[1].Len() == 0 {
+			t.Error("expected the authorized peer to store entries")
+		}
+
+		rec := httptest.NewRecorder()
+		caches[1].ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/_gorm_cache/entry?key=a&ttl=0", nil))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("expected 403, got %d", rec.Code)
+		}
+
+		// Refused without Authorize
+		c := NewPeerCacher(PeerOptions{Self: "http://self", Peers: []string{"http://self"}})
+		defer c.Close()
+		for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+			rec := httptest.NewRecorder()
+			c.ServeHTTP(rec, httptest.NewRequest(method, "/_gorm_cache/entry?key=a&ttl=0", nil))
+			if rec.Code != http.StatusForbidden {
+				t.Errorf("expected 403 for %s without Authorize, got %d", method, rec.Code)
+			}
+		}
+	})
+
+	t.Run("unreachable peer", func(t *testing.T) {
+		c := NewPeerCacher(PeerOptions{Self: "http://self", Peers: []string{"http://self", "http://127.0.0.1:1"}, Replicas: 1})
+		defer c.Close()
+		key := ""
+		for i := 0; key == ""; i++ {
+			if k := fmt.Sprintf("key-%d", i); c.owner(k) != "http://self" {
+				key = k
+			}
+		}
+		var dest int
+		if _, err := c.Get(ctx, key, &Query[any]{Dest: &dest}); err == nil {
+			t.Error("expected the error of the peer to be returned")
+		}
+		if err := c.Invalidate(ctx); err == nil {
+			t.Error("expected the error of the peer to be returned")
+		}
+
+		c.SetPeers("http://self")
+		if _, err := c.Get(ctx, key, &Query[any]{Dest: &dest}); err != nil {
+			t.Errorf("expected the key to be owned once the peer is removed, %v", err)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		caches, _ := newPeers(t, 1, PeerOptions{})
+		rec := httptest.NewRecorder()
+		caches[0].ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_gorm_cache/unknown", nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("expected 404, got %d", rec.Code)
+		}
+	})
+}
+
+// bearerTransport authenticates the requests by an Authorization header
+type bearerTransport string
+
+func (token bearerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+string(token))
+	return http.DefaultTransport.RoundTrip(r)
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"context"
+	"io"
+)
+
+// connPool keeps the connections of the network Cachers, opening at most size of them
+type connPool[C io.Closer] struct {
+	dial  func(ctx context.Context) (C, error)
+	idle  chan C
+	slots chan struct{}
+}
+
+func newConnPool[C io.Closer](size int, dial func(ctx context.Context) (C, error)) *connPool[C] {
+	return &connPool[C]{
+		dial:  dial,
+		idle:  make(chan C, size),
+		slots: make(chan struct{}, size),
+	}
+}
+
+// get returns an idle connection, or a new one if there is room for it
+func (p *connPool[C]) get(ctx context.Context) (C, error) {
+	select {
+	case conn := <-p.idle:
+		return conn, nil
+	default:
+	}
+	select {
+	case conn := <-p.idle:
+		return conn, nil
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		var zero C
+		return zero, ctx.Err()
+	}
+	conn, err := p.dial(ctx)
+	if err != nil {
+		<-p.slots
+	}
+	return conn, err
+}
+
+// put gives the connection back, or closes it when broken
+func (p *connPool[C]) put(conn C, broken bool) {
+	if !broken {
+		select {
+		case p.idle <- conn:
+			return
+		default:
+		}
+	}
+	_ = conn.Close()
+	<-p.slots
+}
+
+// Close closes the idle connections
+func (p *connPool[C]) Close() error {
+	for {
+		select {
+		case conn := <-p.idle:
+			_ = conn.Close()
+			<-p.slots
+		default:
+			return nil
+		}
+	}
+}
@@ -1,7 +1,7 @@
 package cache
 
 import (
-	"encoding/json"
+	"reflect"
 
 	"gorm.io/gorm"
 )
@@ -9,14 +9,51 @@ import (
 type Query[T any] struct {
 	Dest         T
 	RowsAffected int64
+
+	codec Codec
+	meta  Metadata
+	// key is the identifier of the entry, encrypted entries are bound to it
+	key string
+}
+
+// Metadata returns what the envelope of the entry tells about it
+func (q *Query[T]) Metadata() Metadata {
+	return q.meta
 }
 
+// Marshal uses the Codec of the Config, JSONCodec by default
 func (q *Query[T]) Marshal() ([]byte, error) {
-	return json.Marshal(q)
+	if aq, ok := any(q).(*Query[any]); ok {
+		return q.getCodec().Marshal(aq)
+	}
+	return q.getCodec().Marshal(&Query[any]{
+		Dest:         q.Dest,
+		RowsAffected: q.RowsAffected,
+		meta:         q.meta,
+		key:          q.key,
+	})
 }
 
+// Unmarshal decodes into the concrete type of Dest,
+// when it holds a pointer the value is restored into it
 func (q *Query[T]) Unmarshal(bytes []byte) error {
-	return json.Unmarshal(bytes, q)
+	if aq, ok := any(q).(*Query[any]); ok {
+		return q.getCodec().Unmarshal(bytes, aq)
+	}
+	aq := &Query[any]{Dest: &q.Dest, key: q.key}
+	if err := q.getCodec().Unmarshal(bytes, aq); err != nil {
+		return err
+	}
+	q.RowsAffected = aq.RowsAffected
+	q.meta = aq.meta
+	return nil
+}
+
+func (q *Query[T]) getCodec() Codec {
+	if q.codec == nil {
+		return JSONCodec{}
+	}
+	return q.codec
 }
 
 func (q *Query[T]) copyTo(dst *Query[any]) error {
@@ -29,6 +66,11 @@ func (q *Query[T]) copyTo(dst *Query[any]) error {
 }
 
 func (q *Query[T]) replaceOn(db *gorm.DB) {
-	SetPointedValue(db.Statement.Dest, q.Dest)
+	if reflect.ValueOf(db.Statement.Dest).Kind() == reflect.Ptr {
+		SetPointedValue(db.Statement.Dest, q.Dest)
+	}
 	SetPointedValue(&db.Statement.RowsAffected, &q.RowsAffected)
+	if q.RowsAffected == 0 && db.Statement.RaiseErrorOnNotFound {
+		_ = db.AddError(gorm.ErrRecordNotFound)
+	}
 }
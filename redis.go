@@ -0,0 +1,351 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// RedisError is an error replied by the Redis server
+type RedisError string
+
+func (e RedisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// errRedisNil is the reply to GET for a missing key
+var errRedisNil = errors.New("redis: nil")
+
+// RedisOptions configures a RedisCacher
+type RedisOptions struct {
+	// Addr is the host:port of the server, localhost:6379 by default
+	Addr string
+	// Password and DB are sent by AUTH and SELECT, when set
+	Password string
+	DB       int
+	// Prefix is prepended to the keys, so that Invalidate only drops the ones of the cache,
+	// "gorm-cache:" by default
+	Prefix string
+	// PoolSize is the number of connections kept, 10 by default
+	PoolSize int
+	// DialTimeout bounds the connection to the server, 5s by default
+	DialTimeout time.Duration
+}
+
+// RedisCacher is a Cacher storing the serialized queries in Redis, speaking RESP.
+// Entries expire by SET ... PX, the keys of a tag are kept in a set so that InvalidateTags
+// drops them, which expires along with the last of them, while Invalidate drops every key of the prefix
+type RedisCacher struct {
+	opts RedisOptions
+	pool *connPool[*redisConn]
+}
+
+// NewRedisCacher returns a RedisCacher, the connections are opened when needed
+func NewRedisCacher(opts RedisOptions) *RedisCacher {
+	if opts.Addr == "" {
+		opts.Addr = "localhost:6379"
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 10
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	if opts.Prefix == "" {
+		// Invalidate would otherwise drop every key of the database
+		opts.Prefix = "gorm-cache:"
+	}
+	c := &RedisCacher{opts: opts}
+	c.pool = newConnPool(opts.PoolSize, c.dial)
+	return c
+}
+
+func (c *RedisCacher) Get(ctx context.Context, key string, q *Query[any]) (*Query[any], error) {
+	replies, err := c.do(ctx, []string{"GET", c.opts.Prefix + key})
+	if err != nil {
+		return nil, err
+	}
+	if replies[0] == nil {
+		return nil, nil
+	}
+	value, ok := replies[0].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("redis: unexpected reply to GET, %v", replies[0])
+	}
+	if err := q.Unmarshal(value); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Store sets the entry along with the sets of its tags, in a single round trip
+func (c *RedisCacher) Store(ctx context.Context, key string, val *Query[any], d ...time.Duration) error {
+	value, err := val.Marshal()
+	if err != nil {
+		return err
+	}
+	key = c.opts.Prefix + key
+	set, ttl := []string{"SET", key, string(value)}, "0"
+	if len(d) > 0 && d[0] > 0 {
+		ttl = strconv.FormatInt(max(d[0].Milliseconds(), 1), 10)
+		set = append(set, "PX", ttl)
+	}
+	cmds := [][]string{set}
+	for _, tag := range val.Metadata().Tags {
+		cmds = append(cmds, []string{"EVAL", redisTagScript, "1", c.tagKey(tag), key, ttl})
+	}
+	_, err = c.do(ctx, cmds...)
+	return err
+}
+
+// redisTagScript adds ARGV[1] to the set KEYS[1], whose TTL is extended to ARGV[2] milliseconds,
+// the set is persisted when 0, so that it does not expire before any of its members
+const redisTagScript = `local existed = redis.call('EXISTS', KEYS[1])
+redis.call('SADD', KEYS[1], ARGV[1])
+local ttl = tonumber(ARGV[2])
+if ttl == 0 then
+	redis.call('PERSIST', KEYS[1])
+elseif existed == 0 then
+	redis.call('PEXPIRE', KEYS[1], ttl)
+else
+	local current = redis.call('PTTL', KEYS[1])
+	if current >= 0 and current < ttl then
+		redis.call('PEXPIRE', KEYS[1], ttl)
+	end
+end
+return 1`
+
+// Invalidate drops every key of the prefix
+func (c *RedisCacher) Invalidate(ctx context.Context) error {
+	cursor := "0"
+	for {
+		replies, err := c.do(ctx, []string{"SCAN", cursor, "MATCH", escapeRedisPattern(c.opts.Prefix) + "*", "COUNT", "1000"})
+		if err != nil {
+			return err
+		}
+		scan, ok := replies[0].([]any)
+		if !ok || len(scan) != 2 {
+			return fmt.Errorf("redis: unexpected reply to SCAN, %v", replies[0])
+		}
+		next, _ := scan[0].([]byte)
+		keys, _ := scan[1].([]any)
+		if err := c.del(ctx, keys); err != nil {
+			return err
+		}
+		if cursor = string(next); cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
+
+func (c *RedisCacher) Delete(ctx context.Context, key string) error {
+	_, err := c.do(ctx, []string{"DEL", c.opts.Prefix + key})
+	return err
+}
+
+// InvalidateTags drops the keys in the sets of the tags, along with the sets
+func (c *RedisCacher) InvalidateTags(ctx context.Context, tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	cmds := make([][]string, len(tags))
+	for i, tag := range tags {
+		cmds[i] = []string{"SMEMBERS", c.tagKey(tag)}
+	}
+	replies, err := c.do(ctx, cmds...)
+	if err != nil {
+		return err
+	}
+	var keys []any
+	for _, reply := range replies {
+		members, _ := reply.([]any)
+		keys = append(keys, members...)
+	}
+	for _, tag := range tags {
+		keys = append(keys, []byte(c.tagKey(tag)))
+	}
+	return c.del(ctx, keys)
+}
+
+// Close closes the idle connections
+func (c *RedisCacher) Close() error {
+	return c.pool.Close()
+}
+
+func (c *RedisCacher) tagKey(tag string) string {
+	return c.opts.Prefix + "tag:" + tag
+}
+
+func (c *RedisCacher) del(ctx context.Context, keys []any) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	cmd := make([]string, 0, len(keys)+1)
+	cmd = append(cmd, "DEL")
+	for _, key := range keys {
+		if b, ok := key.([]byte); ok {
+			cmd = append(cmd, string(b))
+		}
+	}
+	_, err := c.do(ctx, cmd)
+	return err
+}
+
+// do sends the commands in a pipeline, returning their replies,
+// or the first error replied by the server
+func (c *RedisCacher) do(ctx context.Context, cmds ...[]string) ([]any, error) {
+	conn, err := c.pool.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	replies, err := conn.pipeline(ctx, cmds)
+	var redisErr RedisError
+	if err != nil && !errors.As(err, &redisErr) {
+		// The connection might be left in the middle of a reply
+		c.pool.put(conn, true)
+		return nil, err
+	}
+	c.pool.put(conn, false)
+	return replies, err
+}
+
+func (c *RedisCacher) dial(ctx context.Context) (*redisConn, error) {
+	dialer := net.Dialer{Timeout: c.opts.DialTimeout}
+	nc, err := dialer.DialContext(ctx, "tcp", c.opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+
+	var cmds [][]string
+	if c.opts.Password != "" {
+		cmds = append(cmds, []string{"AUTH", c.opts.Password})
+	}
+	if c.opts.DB != 0 {
+		cmds = append(cmds, []string{"SELECT", strconv.Itoa(c.opts.DB)})
+	}
+	if len(cmds) > 0 {
+		if _, err := conn.pipeline(ctx, cmds); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func (conn *redisConn) pipeline(ctx context.Context, cmds [][]string) ([]any, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else {
+		_ = conn.SetDeadline(time.Time{})
+	}
+	for _, cmd := range cmds {
+		writeRESPCommand(conn.w, cmd)
+	}
+	if err := conn.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	var replyErr error
+	replies := make([]any, len(cmds))
+	for i := range cmds {
+		reply, err := readRESP(conn.r)
+		var redisErr RedisError
+		switch {
+		case errors.As(err, &redisErr):
+			// The other replies are read nevertheless, so that the connection can be reused
+			if replyErr == nil {
+				replyErr = err
+			}
+		case errors.Is(err, errRedisNil):
+		case err != nil:
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, replyErr
+}
+
+func writeRESPCommand(w *bufio.Writer, args []string) {
+	_, _ = w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		_, _ = w.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+		_, _ = w.WriteString(arg)
+		_, _ = w.WriteString("\r\n")
+	}
+}
+
+// readRESP reads a reply, which is a string, []byte, int64, []any or nil
+func readRESP(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, RedisError(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed array length %q", line)
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		elems := make([]any, n)
+		for i := range elems {
+			elem, err := readRESP(r)
+			if err != nil && !errors.Is(err, errRedisNil) {
+				return nil, err
+			}
+			elems[i] = elem
+		}
+		return elems, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
+
+// escapeRedisPattern escapes the glob characters of s, for SCAN MATCH
+func escapeRedisPattern(s string) string {
+	var res []byte
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', ']', '\\':
+			res = append(res, '\\')
+		}
+		res = append(res, s[i])
+	}
+	return string(res)
+}
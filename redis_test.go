@@ -0,0 +1,468 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a RESP server implementing the commands used by RedisCacher
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	mu      sync.Mutex
+	strings map[string][]byte
+	sets    map[string]map[string]struct{}
+	expires map[string]time.Time
+
+	conns    atomic.Int32
+	maxConns atomic.Int32
+	// reads counts the reads of commands from the connections, pipelined ones being read at once
+	reads atomic.Int32
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	s := &fakeRedis{
+		listener: l,
+		password: password,
+		strings:  make(map[string][]byte),
+		sets:     make(map[string]map[string]struct{}),
+		expires:  make(map[string]time.Time),
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *fakeRedis) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeRedis) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		n := s.conns.Add(1)
+		for {
+			m := s.maxConns.Load()
+			if n <= m || s.maxConns.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeRedis) handle(conn net.Conn) {
+	defer s.conns.Add(-1)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	authenticated := s.password == ""
+	for {
+		reply, err := readRESP(r)
+		if err != nil {
+			return
+		}
+		if r.Buffered() == 0 {
+			s.reads.Add(1)
+		}
+		elems, _ := reply.([]any)
+		args := make([]string, len(elems))
+		for i, elem := range elems {
+			b, _ := elem.([]byte)
+			args[i] = string(b)
+		}
+		if len(args) == 0 {
+			return
+		}
+
+		cmd := strings.ToUpper(args[0])
+		switch {
+		case cmd == "AUTH":
+			if len(args) == 2 && args[1] == s.password {
+				authenticated = true
+				_, _ = w.WriteString("+OK\r\n")
+			} else {
+				_, _ = w.WriteString("-WRONGPASS invalid password\r\n")
+			}
+		case !authenticated:
+			_, _ = w.WriteString("-NOAUTH Authentication required.\r\n")
+		default:
+			s.exec(w, cmd, args[1:])
+		}
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *fakeRedis) exec(w *bufio.Writer, cmd string, args []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireLocked()
+
+	switch cmd {
+	case "SELECT":
+		_, _ = w.WriteString("+OK\r\n")
+	case "GET":
+		val, ok := s.strings[args[0]]
+		if !ok {
+			_, _ = w.WriteString("$-1\r\n")
+			return
+		}
+		writeBulk(w, string(val))
+	case "SET":
+		s.strings[args[0]] = []byte(args[1])
+		delete(s.expires, args[0])
+		if len(args) == 4 && strings.ToUpper(args[2]) == "PX" {
+			ms, _ := strconv.Atoi(args[3])
+			s.expires[args[0]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		_, _ = w.WriteString("+OK\r\n")
+	case "DEL":
+		n := 0
+		for _, key := range args {
+			_, str := s.strings[key]
+			_, set := s.sets[key]
+			if str || set {
+				n++
+			}
+			delete(s.strings, key)
+			delete(s.sets, key)
+			delete(s.expires, key)
+		}
+		_, _ = w.WriteString(":" + strconv.Itoa(n) + "\r\n")
+	case "SADD":
+		set, ok := s.sets[args[0]]
+		if !ok {
+			set = make(map[string]struct{})
+			s.sets[args[0]] = set
+		}
+		for _, member := range args[1:] {
+			set[member] = struct{}{}
+		}
+		_, _ = w.WriteString(":" + strconv.Itoa(len(args)-1) + "\r\n")
+	case "EVAL":
+		// Only redisTagScript is run
+		if args[0] != redisTagScript {
+			_, _ = w.WriteString("-ERR unknown script\r\n")
+			return
+		}
+		key, member := args[2], args[3]
+		ttl, _ := strconv.Atoi(args[4])
+		set, existed := s.sets[key]
+		if !existed {
+			set = make(map[string]struct{})
+			s.sets[key] = set
+		}
+		set[member] = struct{}{}
+		expires := time.Now().Add(time.Duration(ttl) * time.Millisecond)
+		current, expiring := s.expires[key]
+		switch {
+		case ttl == 0:
+			delete(s.expires, key)
+		case !existed, expiring && current.Before(expires):
+			s.expires[key] = expires
+		}
+		_, _ = w.WriteString(":1\r\n")
+	case "SMEMBERS":
+		set := s.sets[args[0]]
+		_, _ = w.WriteString("*" + strconv.Itoa(len(set)) + "\r\n")
+		for member := range set {
+			writeBulk(w, member)
+		}
+	case "SCAN":
+		// Two keys at a time, so that the cursor is followed,
+		// which is the hex of the last key returned so that deletions do not make keys skipped
+		after := ""
+		if args[0] != "0" {
+			b, _ := hex.DecodeString(args[0])
+			after = string(b)
+		}
+		var matched []string
+		for _, key := range s.keysLocked() {
+			if ok, _ := path.Match(args[2], key); ok && key > after {
+				matched = append(matched, key)
+			}
+		}
+		next := "0"
+		if len(matched) > 2 {
+			matched = matched[:2]
+			next = hex.EncodeToString([]byte(matched[1]))
+		}
+		_, _ = w.WriteString("*2\r\n")
+		writeBulk(w, next)
+		_, _ = w.WriteString("*" + strconv.Itoa(len(matched)) + "\r\n")
+		for _, key := range matched {
+			writeBulk(w, key)
+		}
+	default:
+		_, _ = w.WriteString("-ERR unknown command '" + cmd + "'\r\n")
+	}
+}
+
+func writeBulk(w *bufio.Writer, s string) {
+	_, _ = w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func (s *fakeRedis) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireLocked()
+	return s.keysLocked()
+}
+
+func (s *fakeRedis) expireLocked() {
+	for key, expires := range s.expires {
+		if !time.Now().Before(expires) {
+			delete(s.strings, key)
+			delete(s.sets, key)
+			delete(s.expires, key)
+		}
+	}
+}
+
+func (s *fakeRedis) keysLocked() []string {
+	var keys []string
+	for key := range s.strings {
+		keys = append(keys, key)
+	}
+	for key := range s.sets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestRedisCacher(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("store and get", func(t *testing.T) {
+		server := newFakeRedis(t, "")
+		c := NewRedisCacher(RedisOptions{Addr: server.Addr(), Prefix: "app:"})
+		defer c.Close()
+
+		if _, ok := lruGet(t, c, "a"); ok {
+			t.Error("a miss was expected")
+		}
+		if err := c.Store(ctx, "a", lruQuery(42)); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if val, ok := lruGet(t, c, "a"); !ok || val != 42 {
+			t.Errorf("expected 42 to be returned, got %d, %t", val, ok)
+		}
+		if keys := server.keys(); len(keys) != 1 || keys[0] != "app:a" {
+			t.Errorf("expected the key to be prefixed, got %v", keys)
+		}
+		_ = c.Delete(ctx, "a")
+		if _, ok := lruGet(t, c, "a"); ok {
+			t.Error("the entry was expected to be deleted")
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		server := newFakeRedis(t, "")
+		c := NewRedisCacher(RedisOptions{Addr: server.Addr()})
+		defer c.Close()
+
+		_ = c.Store(ctx, "short", lruQuery(1), 20*time.Millisecond)
+		_ = c.Store(ctx, "forever", lruQuery(2))
+		if _, ok := lruGet(t, c, "short"); !ok {
+			t.Error("the entry was expected to be returned before it expires")
+		}
+		time.Sleep(40 * time.Millisecond)
+		if _, ok := lruGet(t, c, "short"); ok {
+			t.Error("the expired entry was not expected to be returned")
+		}
+		if _, ok := lruGet(t, c, "forever"); !ok {
+			t.Error("the entry without TTL was expected to be kept")
+		}
+	})
+
+	t.Run("pipelining", func(t *testing.T) {
+		server := newFakeRedis(t, "")
+		c := NewRedisCacher(RedisOptions{Addr: server.Addr()})
+		defer c.Close()
+
+		_ = c.Store(ctx, "warm-up", lruQuery(1))
+		before := server.reads.Load()
+		if err := c.Store(ctx, "a", lruQuery(1, "tag-1", "tag-2")); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if reads := server.reads.Load() - before; reads != 1 {
+			t.Errorf("expected SET and the tags to be sent at once, got %d round trips", reads)
+		}
+	})
+
+	t.Run("invalidation", func(t *testing.T) {
+		server := newFakeRedis(t, "")
+		other := NewRedisCacher(RedisOptions{Addr: server.Addr(), Prefix: "other:"})
+		defer other.Close()
+		_ = other.Store(ctx, "a", lruQuery(0))
+
+		c := NewRedisCacher(RedisOptions{Addr: server.Addr(), Prefix: "app:"})
+		defer c.Close()
+		_ = c.Store(ctx, "a", lruQuery(1, "tenant:a"))
+		_ = c.Store(ctx, "b", lruQuery(2, "tenant:b"))
+		for i := 0; i < 5; i++ {
+			_ = c.Store(ctx, fmt.Sprintf("c-%d", i), lruQuery(i))
+		}
+
+		if err := c.InvalidateTags(ctx, "tenant:a"); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if _, ok := lruGet(t, c, "a"); ok {
+			t.Error("the tagged entry was expected to be invalidated")
+		}
+		if _, ok := lruGet(t, c, "b"); !ok {
+			t.Error("the entries of the other tags were expected to be kept")
+		}
+
+		if err := c.Invalidate(ctx); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if keys := server.keys(); len(keys) != 1 || keys[0] != "other:a" {
+			t.Errorf("expected only the keys of the prefix to be dropped, got %v", keys)
+		}
+	})
+
+	t.Run("default prefix", func(t *testing.T) {
+		server := newFakeRedis(t, "")
+		c := NewRedisCacher(RedisOptions{Addr: server.Addr()})
+		defer c.Close()
+		if _, err := c.do(ctx, []string{"SET", "session:1", "x"}); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		_ = c.Store(ctx, "a", lruQuery(1, "tag"))
+
+		if err := c.Invalidate(ctx); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if keys := server.keys(); len(keys) != 1 || keys[0] != "session:1" {
+			t.Errorf("expected the keys out of the cache to be kept, got %v", keys)
+		}
+	})
+
+	t.Run("tag expiry", func(t *testing.T) {
+		server := newFakeRedis(t, "")
+		c := NewRedisCacher(RedisOptions{Addr: server.Addr(), Prefix: "app:"})
+		defer c.Close()
+
+		_ = c.Store(ctx, "short", lruQuery(1, "short"), 20*time.Millisecond)
+		_ = c.Store(ctx, "a", lruQuery(1, "mixed"), 20*time.Millisecond)
+		_ = c.Store(ctx, "b", lruQuery(2, "mixed"), time.Hour)
+		_ = c.Store(ctx, "c", lruQuery(3, "persistent"))
+		_ = c.Store(ctx, "d", lruQuery(4, "persistent"), 20*time.Millisecond)
+		time.Sleep(40 * time.Millisecond)
+
+		expected := []string{"app:b", "app:c", "app:tag:mixed", "app:tag:persistent"}
+		if keys := server.keys(); fmt.Sprint(keys) != fmt.Sprint(expected) {
+			t.Errorf("expected the sets to expire along with their last key, got %v", keys)
+		}
+		_ = c.InvalidateTags(ctx, "mixed", "persistent")
+		if keys := server.keys(); len(keys) != 0 {
+			t.Errorf("expected the tagged entries to be invalidated, got %v", keys)
+		}
+	})
+
+	t.Run("auth", func(t *testing.T) {
+		server := newFakeRedis(t, "secret")
+		c := NewRedisCacher(RedisOptions{Addr: server.Addr(), Password: "secret", DB: 1})
+		defer c.Close()
+		if err := c.Store(ctx, "a", lruQuery(1)); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+
+		wrong := NewRedisCacher(RedisOptions{Addr: server.Addr(), Password: "wrong"})
+		defer wrong.Close()
+		var redisErr RedisError
+		if err := wrong.Store(ctx, "a", lruQuery(1)); !errors.As(err, &redisErr) {
+			t.Errorf("expected a RedisError, got %v", err)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		server := newFakeRedis(t, "")
+		c := NewRedisCacher(RedisOptions{Addr: server.Addr(), PoolSize: 1})
+		defer c.Close()
+
+		// Error replies leave the connection usable
+		var redisErr RedisError
+		if _, err := c.do(ctx, []string{"UNKNOWN"}, []string{"SET", "a", "b"}); !errors.As(err, &redisErr) {
+			t.Errorf("expected a RedisError, got %v", err)
+		}
+		if replies, err := c.do(ctx, []string{"GET", "a"}); err != nil || string(replies[0].([]byte)) != "b" {
+			t.Errorf("expected the connection to be reused, got %v, %v", replies, err)
+		}
+
+		closed := NewRedisCacher(RedisOptions{Addr: "127.0.0.1:1", DialTimeout: 100 * time.Millisecond})
+		if _, err := closed.Get(ctx, "a", &Query[any]{}); err == nil {
+			t.Error("an error was expected, got none")
+		}
+	})
+
+	t.Run("pool", func(t *testing.T) {
+		server := newFakeRedis(t, "")
+		c := NewRedisCacher(RedisOptions{Addr: server.Addr(), PoolSize: 3})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					key := fmt.Sprintf("key-%d", (i+j)%10)
+					if err := c.Store(ctx, key, lruQuery(j, key)); err != nil {
+						t.Errorf("an unexpected error has occurred, %v", err)
+						return
+					}
+					var dest int
+					if _, err := c.Get(ctx, key, &Query[any]{Dest: &dest}); err != nil {
+						t.Errorf("an unexpected error has occurred, %v", err)
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		if n := server.maxConns.Load(); n > 3 {
+			t.Errorf("expected at most 3 connections, got %d", n)
+		}
+
+		_ = c.Close()
+		deadline := time.Now().Add(time.Second)
+		for server.conns.Load() != 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if n := server.conns.Load(); n != 0 {
+			t.Errorf("expected the connections to be closed, got %d", n)
+		}
+	})
+}
+
+func Test_escapeRedisPattern(t *testing.T) {
+	if actual := escapeRedisPattern(`a*b?[c]\`); actual != `a\*b\?\[c\]\\` {
+		t.Errorf("escapeRedisPattern returned `%s`", actual)
+	}
+}
@@ -8,19 +8,68 @@ import (
 
 // db.Where(maps).Scopes(cache.Cache("xxx", 10)).....
 // 缓存的一个scope。默认是不需要缓存的
+// key 可以是模板，如 "user:{id}"，占位符取自 Bind 或 WHERE 中的等值条件
+// 占位符的值会转义模板中的标点，如 "a:{x}:{y}" 中的 ":"，不同的值不会得到相同的 key
 func Cache(key string, d ...time.Duration) func(*gorm.DB) *gorm.DB {
 	return func(db *gorm.DB) *gorm.DB {
+		if _, ok := db.Plugins[pluginName]; !ok {
+			return db
+		}
+		return withTmp(db, func(tmp *Tmp) {
+			if len(d) > 0 {
+				tmp.Dur = d[0]
+			}
+			tmp.Key = key
+		})
+	}
+}
 
-		v, ok := db.Plugins[pluginName]
-
-		if !ok {
+// db.Scopes(cache.NoNegativeCache()).First(&user, id)
+// 查询结果为空时不缓存
+func NoNegativeCache() func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if _, ok := db.Plugins[pluginName]; !ok {
 			return db
 		}
-		plugin := v.(*Caches)
-		if len(d) > 0 {
-			plugin.tmp.Dur = d[0]
+		return withTmp(db, func(tmp *Tmp) {
+			tmp.SkipNegative = true
+		})
+	}
+}
+
+// db.Scopes(cache.Cache("orders:by-customer:{customer}"), cache.Bind("customer", id)).Find(&orders)
+// 绑定 key 模板中占位符的值
+func Bind(name string, value any) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if _, ok := db.Plugins[pluginName]; !ok {
+			return db
 		}
-		plugin.tmp.Key = key
-		return db
+		return withTmp(db, func(tmp *Tmp) {
+			if tmp.Bindings == nil {
+				tmp.Bindings = make(map[string]any)
+			}
+			tmp.Bindings[name] = value
+		})
+	}
+}
+
+// tmpSetting holds the Tmp of a statement, so that concurrent queries do not share their options
+const tmpSetting = pluginName + ":tmp"
+
+// withTmp updates the Tmp of the statement, which is not inherited by the queries derived from it
+func withTmp(db *gorm.DB, update func(tmp *Tmp)) *gorm.DB {
+	tmp := &Tmp{}
+	if v, ok := db.InstanceGet(tmpSetting); ok {
+		tmp = v.(*Tmp)
+	}
+	update(tmp)
+	return db.InstanceSet(tmpSetting, tmp)
+}
+
+// tmpOf returns the options set by the scopes for the statement
+func tmpOf(db *gorm.DB) *Tmp {
+	if v, ok := db.InstanceGet(tmpSetting); ok {
+		return v.(*Tmp)
 	}
+	return &Tmp{}
 }
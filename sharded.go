@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"context"
+	"hash/maphash"
+	"time"
+)
+
+// ShardedCacher is an in-memory Cacher spreading its entries over LRUCacher shards by the hash of their key,
+// each one with its own lock and eviction list, so that concurrent queries seldom wait for each other
+type ShardedCacher struct {
+	shards []*LRUCacher
+	mask   uint64
+	seed   maphash.Seed
+	janitor
+}
+
+// NewShardedCacher returns a ShardedCacher of shards shards, rounded up to a power of two,
+// holding at most maxEntries entries overall, unbounded when zero.
+// The entries are split evenly among the shards, which are fewer when there are not as many entries.
+// Expired entries are dropped when read and every janitorInterval, unless it is zero.
+// Close stops the janitor
+func NewShardedCacher(shards int, maxEntries int, janitorInterval time.Duration) *ShardedCacher {
+	n := 1
+	for n < shards {
+		n <<= 1
+	}
+	for maxEntries > 0 && n > maxEntries {
+		// Each shard holds an entry at least, as zero would leave it unbounded
+		n >>= 1
+	}
+
+	c := &ShardedCacher{
+		shards:  make([]*LRUCacher, n),
+		mask:    uint64(n - 1),
+		seed:    maphash.MakeSeed(),
+		janitor: newJanitor(),
+	}
+	for i := range c.shards {
+		perShard := 0
+		if maxEntries > 0 {
+			// The remainder goes to the first shards, so that they hold maxEntries overall
+			perShard = maxEntries / n
+			if i < maxEntries%n {
+				perShard++
+			}
+		}
+		c.shards[i] = newLRUCacher(perShard)
+	}
+	c.janitor.start(janitorInterval, c.dropExpired)
+	return c
+}
+
+// WithAdmission sets the admission policy of every shard, look at LRUCacher.WithAdmission.
+// It must be called before the ShardedCacher is used
+func (c *ShardedCacher) WithAdmission(policy AdmissionPolicy) *ShardedCacher {
+	for _, shard := range c.shards {
+		shard.WithAdmission(policy)
+	}
+	return c
+}
+
+func (c *ShardedCacher) shard(key string) *LRUCacher {
+	return c.shards[maphash.String(c.seed, key)&c.mask]
+}
+
+func (c *ShardedCacher) Get(ctx context.Context, key string, q *Query[any]) (*Query[any], error) {
+	return c.shard(key).Get(ctx, key, q)
+}
+
+// Store keeps val until d[0] is elapsed, if any
+func (c *ShardedCacher) Store(ctx context.Context, key string, val *Query[any], d ...time.Duration) error {
+	return c.shard(key).Store(ctx, key, val, d...)
+}
+
+func (c *ShardedCacher) Invalidate(ctx context.Context) error {
+	for _, shard := range c.shards {
+		_ = shard.Invalidate(ctx)
+	}
+	return nil
+}
+
+func (c *ShardedCacher) Delete(ctx context.Context, key string) error {
+	return c.shard(key).Delete(ctx, key)
+}
+
+func (c *ShardedCacher) InvalidateTags(ctx context.Context, tags ...string) error {
+	for _, shard := range c.shards {
+		_ = shard.InvalidateTags(ctx, tags...)
+	}
+	return nil
+}
+
+// Len returns the number of entries, including the expired ones which were not dropped yet
+func (c *ShardedCacher) Len() int {
+	n := 0
+	for _, shard := range c.shards {
+		n += shard.Len()
+	}
+	return n
+}
+
+func (c *ShardedCacher) dropExpired() {
+	for _, shard := range c.shards {
+		shard.dropExpired()
+	}
+}
@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShardedCacher(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("shards", func(t *testing.T) {
+		for shards, expected := range map[int]int{0: 1, 1: 1, 3: 4, 16: 16, 17: 32} {
+			if actual := len(NewShardedCacher(shards, 0, 0).shards); actual != expected {
+				t.Errorf("expected %d shards for %d, got %d", expected, shards, actual)
+			}
+		}
+	})
+
+	t.Run("store and get", func(t *testing.T) {
+		c := NewShardedCacher(8, 0, 0)
+		for i := 0; i < 100; i++ {
+			_ = c.Store(ctx, fmt.Sprintf("key-%d", i), lruQuery(i, fmt.Sprintf("tag-%d", i%2)))
+		}
+		if c.Len() != 100 {
+			t.Errorf("expected 100 entries, got %d", c.Len())
+		}
+		for i := 0; i < 100; i++ {
+			if val, ok := lruGet(t, c, fmt.Sprintf("key-%d", i)); !ok || val != i {
+				t.Errorf("expected %d to be returned, got %d, %t", i, val, ok)
+			}
+		}
+
+		_ = c.Delete(ctx, "key-0")
+		if _, ok := lruGet(t, c, "key-0"); ok {
+			t.Error("the entry was expected to be deleted")
+		}
+		_ = c.InvalidateTags(ctx, "tag-1")
+		if c.Len() != 49 {
+			t.Errorf("expected 49 entries after the invalidation of a tag, got %d", c.Len())
+		}
+		_ = c.Invalidate(ctx)
+		if c.Len() != 0 {
+			t.Errorf("no entry was expected to be kept, got %d", c.Len())
+		}
+	})
+
+	t.Run("bounded", func(t *testing.T) {
+		c := NewShardedCacher(4, 100, 0)
+		for i := 0; i < 1000; i++ {
+			_ = c.Store(ctx, fmt.Sprintf("key-%d", i), lruQuery(i))
+		}
+		if c.Len() > 100 {
+			t.Errorf("expected at most 100 entries, got %d", c.Len())
+		}
+	})
+
+	t.Run("capacity", func(t *testing.T) {
+		for _, tc := range []struct{ shards, maxEntries, expected int }{{4, 100, 4}, {8, 10, 8}, {16, 5, 4}, {64, 1, 1}} {
+			c := NewShardedCacher(tc.shards, tc.maxEntries, 0)
+			if len(c.shards) != tc.expected {
+				t.Errorf("expected %d shards for %d entries, got %d", tc.expected, tc.maxEntries, len(c.shards))
+			}
+			total := 0
+			for _, shard := range c.shards {
+				if shard.maxEntries == 0 {
+					t.Errorf("expected the shards of %d entries to be bounded", tc.maxEntries)
+				}
+				total += shard.maxEntries
+			}
+			if total != tc.maxEntries {
+				t.Errorf("expected the shards to hold %d entries overall, got %d", tc.maxEntries, total)
+			}
+		}
+	})
+
+	t.Run("janitor", func(t *testing.T) {
+		c := NewShardedCacher(4, 0, time.Millisecond)
+		defer c.Close()
+		for i := 0; i < 10; i++ {
+			_ = c.Store(ctx, fmt.Sprintf("key-%d", i), lruQuery(i), time.Millisecond)
+		}
+		_ = c.Store(ctx, "forever", lruQuery(1))
+
+		deadline := time.Now().Add(time.Second)
+		for c.Len() != 1 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if c.Len() != 1 {
+			t.Errorf("the janitor was expected to drop the expired entries, got %d entries", c.Len())
+		}
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		c := NewShardedCacher(8, 64, time.Millisecond)
+		defer c.Close()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 500; j++ {
+					key := fmt.Sprintf("key-%d", (i*j)%100)
+					switch j % 5 {
+					case 0:
+						_ = c.Delete(ctx, key)
+					case 1:
+						_ = c.InvalidateTags(ctx, key)
+					default:
+						_ = c.Store(ctx, key, lruQuery(j, key), time.Duration(j%3)*time.Millisecond)
+					}
+					var dest int
+					_, _ = c.Get(ctx, key, &Query[any]{Dest: &dest})
+				}
+			}(i)
+		}
+		wg.Wait()
+		if c.Len() > 64 {
+			t.Errorf("expected at most 64 entries, got %d", c.Len())
+		}
+	})
+}
+
+// BenchmarkMemoryCachers compares the single lock of LRUCacher with the shards of ShardedCacher,
+// the queries reading 9 times out of 10
+func BenchmarkMemoryCachers(b *testing.B) {
+	const keys = 1024
+	ctx := context.Background()
+	names := make([]string, keys)
+	for i := range names {
+		names[i] = fmt.Sprintf("key-%d", i)
+	}
+
+	for _, cacher := range []struct {
+		name string
+		new  func() Cacher
+	}{
+		{"single-lock", func() Cacher { return NewLRUCacher(keys, 0) }},
+		{"sharded", func() Cacher { return NewShardedCacher(64, keys, 0) }},
+	} {
+		for _, goroutines := range []int{1, 4, 16, 64} {
+			b.Run(fmt.Sprintf("%s/%d", cacher.name, goroutines), func(b *testing.B) {
+				c := cacher.new()
+				for i, key := range names {
+					_ = c.Store(ctx, key, lruQuery(i))
+				}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				var wg sync.WaitGroup
+				for g := 0; g < goroutines; g++ {
+					n := b.N / goroutines
+					if g < b.N%goroutines {
+						n++
+					}
+					wg.Add(1)
+					go func(g, n int) {
+						defer wg.Done()
+						for i := 0; i < n; i++ {
+							key := names[(g*7919+i)%keys]
+							if i%10 == 0 {
+								_ = c.Store(ctx, key, lruQuery(i))
+								continue
+							}
+							var dest int
+							_, _ = c.Get(ctx, key, &Query[any]{Dest: &dest})
+						}
+					}(g, n)
+				}
+				wg.Wait()
+			})
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrCachedDB is returned by NewSQLCacher when the database has the Caches plugin,
+// whose queries of the entries would be cached themselves
+var ErrCachedDB = errors.New("cache: the database of a SQLCacher must not have the Caches plugin")
+
+// sqlEntry is a row of the table of a SQLCacher, the tables and tags are kept as |a|b|
+type sqlEntry struct {
+	// KeyHash is the primary key, the keys being too long for some databases to index them
+	KeyHash   string `gorm:"primaryKey;size:64"`
+	Key       string
+	Value     []byte
+	ExpiresAt *time.Time `gorm:"index"`
+	Tables    string
+	Tags      string
+}
+
+// SQLCacher is a Cacher keeping its entries in a table of another database, through gorm,
+// such as for caching the analytical queries of a warehouse in an OLTP database.
+// The entries are invalidated by table or tag with LIKE, which may be case-insensitive,
+// so that more entries than needed may be invalidated
+type SQLCacher struct {
+	db    *gorm.DB
+	table string
+	now   func() time.Time
+	janitor
+}
+
+// NewSQLCacher returns a SQLCacher keeping its entries in table, gorm_cache_entries when empty,
+// which is migrated along with its index on the expiry.
+// Expired entries are deleted when read, by Cleanup, and every janitorInterval unless it is zero.
+// Close stops the janitor, db is left open
+func NewSQLCacher(db *gorm.DB, table string, janitorInterval time.Duration) (*SQLCacher, error) {
+	if _, ok := db.Config.Plugins[pluginName]; ok {
+		return nil, ErrCachedDB
+	}
+	if table == "" {
+		table = "gorm_cache_entries"
+	}
+	if err := db.Table(table).AutoMigrate(&sqlEntry{}); err != nil {
+		return nil, err
+	}
+
+	c := &SQLCacher{db: db, table: table, now: time.Now, janitor: newJanitor()}
+	c.janitor.start(janitorInterval, func() {
+		_ = c.Cleanup(context.Background())
+	})
+	return c, nil
+}
+
+func (c *SQLCacher) Get(ctx context.Context, key string, q *Query[any]) (*Query[any], error) {
+	var entry sqlEntry
+	err := c.tx(ctx).Where("key_hash = ?", sqlKeyHash(key)).Take(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if entry.Key != key {
+		// A collision of hashes, as unlikely as it is
+		return nil, nil
+	}
+
+	now := c.now().UTC()
+	if entry.ExpiresAt != nil && !now.Before(*entry.ExpiresAt) {
+		// Unless it was replaced in the meantime
+		return nil, c.tx(ctx).Where("key_hash = ? AND expires_at <= ?", entry.KeyHash, now).Delete(&sqlEntry{}).Error
+	}
+	if err := q.Unmarshal(entry.Value); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Store inserts or replaces the row of key
+func (c *SQLCacher) Store(ctx context.Context, key string, val *Query[any], d ...time.Duration) error {
+	value, err := val.Marshal()
+	if err != nil {
+		return err
+	}
+	entry := sqlEntry{
+		KeyHash: sqlKeyHash(key),
+		Key:     key,
+		Value:   value,
+		Tables:  sqlList(val.Metadata().Tables),
+		Tags:    sqlList(val.Metadata().Tags),
+	}
+	if len(d) > 0 && d[0] > 0 {
+		expires := c.now().Add(d[0]).UTC()
+		entry.ExpiresAt = &expires
+	}
+	return c.tx(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&entry).Error
+}
+
+// Invalidate deletes every row
+func (c *SQLCacher) Invalidate(ctx context.Context) error {
+	return c.tx(ctx).Session(&gorm.Session{AllowGlobalUpdate: true}).Delete(&sqlEntry{}).Error
+}
+
+func (c *SQLCacher) Delete(ctx context.Context, key string) error {
+	return c.tx(ctx).Where("key_hash = ?", sqlKeyHash(key)).Delete(&sqlEntry{}).Error
+}
+
+func (c *SQLCacher) InvalidateTags(ctx context.Context, tags ...string) error {
+	return c.deleteListed(ctx, "tags", tags)
+}
+
+// InvalidateTables deletes the entries of the queries which read any of the tables
+func (c *SQLCacher) InvalidateTables(ctx context.Context, tables ...string) error {
+	return c.deleteListed(ctx, "tables", tables)
+}
+
+// Cleanup deletes the expired entries
+func (c *SQLCacher) Cleanup(ctx context.Context) error {
+	return c.tx(ctx).Where("expires_at <= ?", c.now().UTC()).Delete(&sqlEntry{}).Error
+}
+
+// deleteListed deletes the rows whose column lists any of the names, in a single statement
+func (c *SQLCacher) deleteListed(ctx context.Context, column string, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	exprs := make([]clause.Expression, len(names))
+	for i, name := range names {
+		exprs[i] = clause.Expr{
+			SQL:  "? LIKE ? ESCAPE '!'",
+			Vars: []any{clause.Column{Name: column}, "%|" + escapeLike(name) + "|%"},
+		}
+	}
+	return c.tx(ctx).Where(clause.Or(exprs...)).Delete(&sqlEntry{}).Error
+}
+
+func (c *SQLCacher) tx(ctx context.Context) *gorm.DB {
+	return c.db.WithContext(ctx).Table(c.table)
+}
+
+func sqlKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// sqlList returns |a|b|, so that LIKE '%|a|%' matches the lists of a
+func sqlList(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	return "|" + strings.Join(names, "|") + "|"
+}
+
+// escapeLike escapes the wildcards of s with !, as backslashes are escapes of MySQL strings themselves
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
@@ -0,0 +1,171 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newSQLCacher(t *testing.T) *SQLCacher {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "cache.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	c, err := NewSQLCacher(db, "", 0)
+	if err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	t.Cleanup(func() {
+		_ = c.Close()
+		if sqlDB, err := db.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+	return c
+}
+
+func countRows(t *testing.T, c *SQLCacher) int64 {
+	t.Helper()
+	var n int64
+	if err := c.db.Table(c.table).Count(&n).Error; err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	return n
+}
+
+func TestSQLCacher(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("migration", func(t *testing.T) {
+		c := newSQLCacher(t)
+		if !c.db.Migrator().HasTable("gorm_cache_entries") {
+			t.Error("expected the table to be created")
+		}
+		if !c.db.Table(c.table).Migrator().HasIndex(&sqlEntry{}, "ExpiresAt") {
+			t.Error("expected the expiry to be indexed")
+		}
+		// Migrated again
+		if _, err := NewSQLCacher(c.db, "", 0); err != nil {
+			t.Errorf("an unexpected error has occurred, %v", err)
+		}
+
+		db, _ := gorm.Open(c.db.Dialector, &gorm.Config{Logger: logger.Discard})
+		_ = db.Use(&Caches{Conf: &Config{}})
+		if _, err := NewSQLCacher(db, "", 0); !errors.Is(err, ErrCachedDB) {
+			t.Errorf("expected ErrCachedDB, got %v", err)
+		}
+	})
+
+	t.Run("store and get", func(t *testing.T) {
+		c := newSQLCacher(t)
+		if _, ok := lruGet(t, c, "a"); ok {
+			t.Error("a miss was expected")
+		}
+		_ = c.Store(ctx, "a", lruQuery(1))
+		if err := c.Store(ctx, "a", lruQuery(2)); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if val, ok := lruGet(t, c, "a"); !ok || val != 2 {
+			t.Errorf("expected 2 to be returned, got %d, %t", val, ok)
+		}
+
+		long := "SELECT * FROM users WHERE " + strings.Repeat("id = 1 OR ", 100) + "id = 2"
+		_ = c.Store(ctx, long, lruQuery(3))
+		if val, ok := lruGet(t, c, long); !ok || val != 3 {
+			t.Errorf("expected 3 to be returned for a long key, got %d, %t", val, ok)
+		}
+
+		_ = c.Delete(ctx, "a")
+		if _, ok := lruGet(t, c, "a"); ok {
+			t.Error("the entry was expected to be deleted")
+		}
+		if n := countRows(t, c); n != 1 {
+			t.Errorf("expected 1 row, got %d", n)
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		c := newSQLCacher(t)
+		now := time.Now()
+		c.now = func() time.Time { return now }
+
+		_ = c.Store(ctx, "short", lruQuery(1), time.Second)
+		_ = c.Store(ctx, "long", lruQuery(2), time.Hour)
+		_ = c.Store(ctx, "forever", lruQuery(3))
+		now = now.Add(time.Minute)
+
+		if _, ok := lruGet(t, c, "short"); ok {
+			t.Error("the expired entry was not expected to be returned")
+		}
+		if n := countRows(t, c); n != 2 {
+			t.Errorf("the expired entry was expected to be deleted when read, got %d rows", n)
+		}
+
+		now = now.Add(2 * time.Hour)
+		if err := c.Cleanup(ctx); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		if n := countRows(t, c); n != 1 {
+			t.Errorf("the expired entries were expected to be cleaned up, got %d rows", n)
+		}
+		if _, ok := lruGet(t, c, "forever"); !ok {
+			t.Error("the entry without TTL was expected to be kept")
+		}
+	})
+
+	t.Run("invalidation", func(t *testing.T) {
+		c := newSQLCacher(t)
+		store := func() {
+			_ = c.Store(ctx, "users:1", fileQuery(1, []string{"users"}, []string{"tenant_a"}))
+			_ = c.Store(ctx, "users:2", fileQuery(2, []string{"users", "pets"}, []string{"tenant:b"}))
+			_ = c.Store(ctx, "pets:1", fileQuery(3, []string{"pets"}, []string{"tenantxa"}))
+			_ = c.Store(ctx, "users:10", fileQuery(4, []string{"users"}, []string{"tenant_a"}))
+		}
+		kept := func(keys ...string) {
+			t.Helper()
+			assertKept(t, c, []string{"users:1", "users:2", "pets:1", "users:10"}, keys...)
+		}
+
+		store()
+		_ = c.InvalidateTables(ctx, "pets")
+		kept("users:1", "users:10")
+
+		store()
+		_ = c.InvalidateTables(ctx, "user")
+		kept("users:1", "users:2", "pets:1", "users:10")
+
+		store()
+		// _ is not a wildcard
+		_ = c.InvalidateTags(ctx, "tenant_a", "tenant:b")
+		kept("pets:1")
+
+		store()
+		_ = c.Invalidate(ctx)
+		kept()
+	})
+
+	t.Run("janitor", func(t *testing.T) {
+		c := newSQLCacher(t)
+		other, err := NewSQLCacher(c.db, "", time.Millisecond)
+		if err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		defer other.Close()
+		_ = c.Store(ctx, "a", lruQuery(1), time.Millisecond)
+
+		deadline := time.Now().Add(time.Second)
+		for countRows(t, c) != 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if n := countRows(t, c); n != 0 {
+			t.Errorf("the janitor was expected to clean up the expired entry, got %d rows", n)
+		}
+	})
+}
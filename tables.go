@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"sort"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// tablesOf returns the tables a query reads from, as far as the statement tells:
+// the ones of raw SQL and sub queries are not known
+func tablesOf(db *gorm.DB) []string {
+	set := make(map[string]struct{})
+	add := func(table string) {
+		if table != "" {
+			set[table] = struct{}{}
+		}
+	}
+
+	add(db.Statement.Table)
+	if c, ok := db.Statement.Clauses["FROM"]; ok {
+		if from, ok := c.Expression.(clause.From); ok {
+			for _, table := range from.Tables {
+				add(table.Name)
+			}
+			for _, join := range from.Joins {
+				add(join.Table.Name)
+			}
+		}
+	}
+	if db.Statement.Schema != nil {
+		for _, join := range db.Statement.Joins {
+			if rel, ok := db.Statement.Schema.Relationships.Relations[join.Name]; ok {
+				add(rel.FieldSchema.Table)
+			}
+		}
+	}
+
+	tables := make([]string, 0, len(set))
+	for table := range set {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	return tables
+}
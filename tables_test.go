@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/utils/tests"
+)
+
+func Test_tablesOf(t *testing.T) {
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+	if err != nil {
+		t.Fatalf("gorm initialization resulted into an unexpected error, %s", err.Error())
+	}
+
+	testCases := map[string]struct {
+		db       *gorm.DB
+		expected []string
+	}{
+		"model": {
+			db:       db.Find(&[]tests.User{}),
+			expected: []string{"users"},
+		},
+		"joins": {
+			db:       db.Joins("Company").Joins("Manager").Find(&[]tests.User{}),
+			expected: []string{"companies", "users"},
+		},
+		"from clause": {
+			db: db.Clauses(clause.From{
+				Tables: []clause.Table{{Name: "pets"}},
+				Joins:  []clause.Join{{Table: clause.Table{Name: "toys"}}},
+			}).Find(&[]tests.Pet{}),
+			expected: []string{"pets", "toys"},
+		},
+		"raw": {
+			db:       db.Raw("SELECT * FROM users").Scan(&[]tests.User{}),
+			expected: []string{},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if actual := tablesOf(tc.db); !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("tablesOf expected to return %v but got %v", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"context"
+	"net/url"
+)
+
+// tenantOf returns the tenant of a query, look at Config.TenantFromContext
+func (c *Caches) tenantOf(ctx context.Context) (string, bool) {
+	if c.Conf.TenantFromContext == nil || ctx == nil {
+		return "", false
+	}
+	return c.Conf.TenantFromContext(ctx)
+}
+
+// tenantScope is prepended to the identifiers of the tenant's queries
+func (c *Caches) tenantScope(ctx context.Context) string {
+	if id, ok := c.tenantOf(ctx); ok {
+		return tenantTag(id) + ":"
+	}
+	return ""
+}
+
+// tagsOf returns the tags the entries stored within ctx are invalidated by
+func (c *Caches) tagsOf(ctx context.Context) []string {
+	if id, ok := c.tenantOf(ctx); ok {
+		return []string{tenantTag(id)}
+	}
+	return nil
+}
+
+func tenantTag(id string) string {
+	return "tenant:" + url.QueryEscape(id)
+}
+
+// invalidate is called upon INSERT / UPDATE / DELETE queries,
+// within a tenant it only invalidates the tenant's entries
+func (c *Caches) invalidate(ctx context.Context) error {
+	if id, ok := c.tenantOf(ctx); ok {
+		return c.InvalidateTenant(ctx, id)
+	}
+	return c.Conf.Cacher.Invalidate(ctx)
+}
+
+// InvalidateTenant invalidates the cached results of a tenant,
+// or all of them when the Cacher is not a TagInvalidator
+func (c *Caches) InvalidateTenant(ctx context.Context, id string) error {
+	if c.Conf.Cacher == nil {
+		return nil
+	}
+	if invalidator, ok := c.Conf.Cacher.(TagInvalidator); ok {
+		return invalidator.InvalidateTags(ctx, tenantTag(id))
+	}
+	return c.Conf.Cacher.Invalidate(ctx)
+}
@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+type tenantKey struct{}
+
+func tenantFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(tenantKey{}).(string)
+	return id, ok
+}
+
+func TestCaches_tenant(t *testing.T) {
+	newCaches := func(cacher Cacher) (*Caches, *int) {
+		var incr int
+		return &Caches{
+			Conf: &Config{Cacher: cacher, TenantFromContext: tenantFromContext},
+			callbacks: map[queryType]func(db *gorm.DB){
+				uponQuery: func(db *gorm.DB) {
+					incr++
+					id, _ := tenantFromContext(db.Statement.Context)
+					db.Statement.Dest.(*mockDest).Result = id
+					db.Statement.RowsAffected = 1
+				},
+			},
+		}, &incr
+	}
+	query := func(caches *Caches, tenant string, tmp ...*Tmp) string {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Context = context.WithValue(context.Background(), tenantKey{}, tenant)
+		db.Statement.Dest = &mockDest{}
+		db.Statement.SQL.WriteString("demo-query")
+		if len(tmp) > 0 {
+			setTmp(db, tmp[0])
+		}
+		caches.query(db)
+		if db.Error != nil {
+			t.Fatalf("an unexpected error has occurred, %v", db.Error)
+		}
+		return db.Statement.Dest.(*mockDest).Result
+	}
+	mutate := func(caches *Caches, tenant string) {
+		db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+		db.Statement.Context = context.WithValue(context.Background(), tenantKey{}, tenant)
+		caches.getMutatorCb(uponUpdate)(db)
+		if db.Error != nil {
+			t.Fatalf("an unexpected error has occurred, %v", db.Error)
+		}
+	}
+
+	t.Run("scoped identifiers", func(t *testing.T) {
+		cacher := &cacherTagMock{}
+		caches, incr := newCaches(cacher)
+
+		for i := 0; i < 2; i++ {
+			if res := query(caches, "a"); res != "a" {
+				t.Errorf("tenant a was expected to get its own result, got `%s`", res)
+			}
+			if res := query(caches, "b"); res != "b" {
+				t.Errorf("tenant b was expected to get its own result, got `%s`", res)
+			}
+		}
+		if *incr != 2 {
+			t.Errorf("expected the query to run once per tenant, but %d times", *incr)
+		}
+
+		cacher.store.Range(func(key, _ any) bool {
+			if !strings.HasPrefix(key.(string), IdentifierPrefix+"dummy:tenant:") {
+				t.Errorf("the identifier `%s` was expected to be scoped to its tenant", key)
+			}
+			return true
+		})
+	})
+
+	t.Run("scope key", func(t *testing.T) {
+		cacher := &cacherTagMock{}
+		caches, incr := newCaches(cacher)
+
+		for _, tenant := range []string{"a", "b"} {
+			query(caches, tenant, &Tmp{Key: "user:1"})
+		}
+		if *incr != 2 {
+			t.Errorf("expected the query to run once per tenant, but %d times", *incr)
+		}
+		if _, ok := cacher.store.Load("tenant:a:user:1"); !ok {
+			t.Error("the key of the Cache scope was expected to be scoped to its tenant")
+		}
+	})
+
+	t.Run("mutations", func(t *testing.T) {
+		cacher := &cacherTagMock{}
+		caches, incr := newCaches(cacher)
+
+		query(caches, "a")
+		query(caches, "b")
+		mutate(caches, "a")
+		query(caches, "a")
+		query(caches, "b")
+
+		if *incr != 3 {
+			t.Errorf("expected a mutation to only invalidate the tenant's entries, the query ran %d times", *incr)
+		}
+		if cacher.invalidations != 0 {
+			t.Errorf("expected no global invalidation, got %d", cacher.invalidations)
+		}
+	})
+
+	t.Run("InvalidateTenant", func(t *testing.T) {
+		cacher := &cacherTagMock{}
+		caches, incr := newCaches(cacher)
+
+		query(caches, "a")
+		query(caches, "b")
+		if err := caches.InvalidateTenant(context.Background(), "b"); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		query(caches, "a")
+		query(caches, "b")
+
+		if *incr != 3 {
+			t.Errorf("expected InvalidateTenant to only invalidate the tenant's entries, the query ran %d times", *incr)
+		}
+	})
+
+	t.Run("without TagInvalidator", func(t *testing.T) {
+		cacher := &cacherBytesMock{}
+		caches, incr := newCaches(cacher)
+
+		query(caches, "a")
+		query(caches, "b")
+		if err := caches.InvalidateTenant(context.Background(), "b"); err != nil {
+			t.Fatalf("an unexpected error has occurred, %v", err)
+		}
+		query(caches, "a")
+
+		if *incr != 3 {
+			t.Errorf("expected InvalidateTenant to invalidate all entries, the query ran %d times", *incr)
+		}
+	})
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// TieredCacher is a Cacher reading a small in-process L1 then a shared L2, such as an LRUCacher
+// in front of a RedisCacher. The entries found in L2 are copied to L1 for a shorter TTL,
+// which bounds how long a process may serve an entry invalidated by another one
+type TieredCacher struct {
+	L1, L2 Cacher
+	// L1TTL is the TTL of the entries in L1, unless the one they are stored for is shorter
+	L1TTL time.Duration
+}
+
+// NewTieredCacher returns a TieredCacher keeping the entries in l1 for l1TTL at most
+func NewTieredCacher(l1, l2 Cacher, l1TTL time.Duration) *TieredCacher {
+	return &TieredCacher{L1: l1, L2: l2, L1TTL: l1TTL}
+}
+
+// Get reads L1 then L2, an unreadable entry in L1 is deleted and read from L2.
+// The entries of L2 are back-filled for the rest of their TTL at most, unless it is over
+func (c *TieredCacher) Get(ctx context.Context, key string, q *Query[any]) (*Query[any], error) {
+	res, err := c.L1.Get(ctx, key, q)
+	if errors.Is(err, ErrInvalidEntry) {
+		if deleter, ok := c.L1.(Deleter); ok {
+			_ = deleter.Delete(ctx, key)
+		}
+	} else if err != nil || res != nil {
+		return res, err
+	}
+
+	res, err = c.L2.Get(ctx, key, q)
+	if err != nil || res == nil {
+		return res, err
+	}
+	// Failing to back-fill only costs another read of L2
+	if ttl, ok := c.backFillTTL(res.Metadata()); ok {
+		_ = c.L1.Store(ctx, key, res, ttl...)
+	}
+	return res, nil
+}
+
+// Store stores val in L2 for d[0], if any, then in L1 for the shortest of d[0] and L1TTL
+func (c *TieredCacher) Store(ctx context.Context, key string, val *Query[any], d ...time.Duration) error {
+	if err := c.L2.Store(ctx, key, val, d...); err != nil {
+		return err
+	}
+	l1 := c.l1TTL()
+	if len(d) > 0 && d[0] > 0 && (len(l1) == 0 || d[0] < l1[0]) {
+		l1 = d[:1]
+	}
+	return c.L1.Store(ctx, key, val, l1...)
+}
+
+// Invalidate invalidates L2 then L1, so that L1 is not back-filled with the entries of L2 meanwhile
+func (c *TieredCacher) Invalidate(ctx context.Context) error {
+	return errors.Join(c.L2.Invalidate(ctx), c.L1.Invalidate(ctx))
+}
+
+// Delete deletes the entry from the tiers which are Deleters
+func (c *TieredCacher) Delete(ctx context.Context, key string) error {
+	var errs []error
+	for _, tier := range []Cacher{c.L2, c.L1} {
+		if deleter, ok := tier.(Deleter); ok {
+			errs = append(errs, deleter.Delete(ctx, key))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Release tells the tiers which are Releasers that the entry of key will not be stored
+func (c *TieredCacher) Release(ctx context.Context, key string) error {
+	var errs []error
+	for _, tier := range []Cacher{c.L2, c.L1} {
+		if releaser, ok := tier.(Releaser); ok {
+			errs = append(errs, releaser.Release(ctx, key))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// InvalidateTags invalidates the tags in both tiers, the ones which are not TagInvalidators are invalidated entirely
+func (c *TieredCacher) InvalidateTags(ctx context.Context, tags ...string) error {
+	var errs []error
+	for _, tier := range []Cacher{c.L2, c.L1} {
+		if invalidator, ok := tier.(TagInvalidator); ok {
+			errs = append(errs, invalidator.InvalidateTags(ctx, tags...))
+		} else {
+			errs = append(errs, tier.Invalidate(ctx))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// backFillTTL returns the TTL of an entry of L2 in L1, the shortest of L1TTL and the rest of its own TTL,
+// or false when the latter is over
+func (c *TieredCacher) backFillTTL(meta Metadata) ([]time.Duration, bool) {
+	ttl := c.l1TTL()
+	if meta.TTL <= 0 || meta.CreatedAt.IsZero() {
+		return ttl, true
+	}
+	left := time.Until(meta.CreatedAt.Add(meta.TTL))
+	if left <= 0 {
+		return nil, false
+	}
+	if len(ttl) == 0 || left < ttl[0] {
+		ttl = []time.Duration{left}
+	}
+	return ttl, true
+}
+
+func (c *TieredCacher) l1TTL() []time.Duration {
+	if c.L1TTL > 0 {
+		return []time.Duration{c.L1TTL}
+	}
+	return nil
+}
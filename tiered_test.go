@@ -0,0 +1,222 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// tieredQuery is wrapped in the envelope, so that its tags are read back
+func tieredQuery(val int, tags ...string) *Query[any] {
+	q := lruQuery(val, tags...)
+	q.codec = envelopeCodec{Codec: JSONCodec{}}
+	return q
+}
+
+func tieredGet(t *testing.T, c Cacher, key string) (int, bool) {
+	t.Helper()
+	var dest int
+	res, err := c.Get(context.Background(), key, &Query[any]{Dest: &dest, codec: envelopeCodec{Codec: JSONCodec{}}})
+	if err != nil {
+		t.Fatalf("an unexpected error has occurred, %v", err)
+	}
+	return dest, res != nil
+}
+
+func TestTieredCacher(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("read through", func(t *testing.T) {
+		l1, l2 := newLRUCacher(0), newLRUCacher(0)
+		c := NewTieredCacher(l1, l2, time.Minute)
+		if _, ok := lruGet(t, c, "a"); ok {
+			t.Error("a miss was expected")
+		}
+
+		_ = c.Store(ctx, "a", lruQuery(1))
+		if _, ok := lruGet(t, l1, "a"); !ok {
+			t.Error("the entry was expected to be stored in L1")
+		}
+		if _, ok := lruGet(t, l2, "a"); !ok {
+			t.Error("the entry was expected to be stored in L2")
+		}
+
+		// Stored by another process
+		_ = l2.Store(ctx, "b", tieredQuery(2, "tag"))
+		if val, ok := tieredGet(t, c, "b"); !ok || val != 2 {
+			t.Errorf("expected 2 to be read from L2, got %d, %t", val, ok)
+		}
+		if val, ok := tieredGet(t, l1, "b"); !ok || val != 2 {
+			t.Errorf("expected L1 to be back-filled, got %d, %t", val, ok)
+		}
+		_ = c.InvalidateTags(ctx, "tag")
+		if _, ok := lruGet(t, l1, "b"); ok {
+			t.Error("expected the back-filled entry to keep its tags")
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		l1, l2 := &cacherTTLMock{}, &cacherTTLMock{}
+		c := NewTieredCacher(l1, l2, time.Minute)
+		ttl := func(m *cacherTTLMock, key string) time.Duration {
+			d, _ := m.ttl.Load(key)
+			d2, _ := d.(time.Duration)
+			return d2
+		}
+
+		_ = c.Store(ctx, "long", lruQuery(1), time.Hour)
+		if d := ttl(l1, "long"); d != time.Minute {
+			t.Errorf("expected the entry to be kept a minute in L1, got %s", d)
+		}
+		if d := ttl(l2, "long"); d != time.Hour {
+			t.Errorf("expected the entry to be kept an hour in L2, got %s", d)
+		}
+
+		_ = c.Store(ctx, "short", lruQuery(1), time.Second)
+		if d := ttl(l1, "short"); d != time.Second {
+			t.Errorf("expected the entry to be kept a second in L1, got %s", d)
+		}
+
+		_ = c.Store(ctx, "forever", lruQuery(1))
+		if d := ttl(l1, "forever"); d != time.Minute {
+			t.Errorf("expected the entry to be kept a minute in L1, got %s", d)
+		}
+
+		_ = l2.Store(ctx, "remote", lruQuery(1))
+		lruGet(t, c, "remote")
+		if d := ttl(l1, "remote"); d != time.Minute {
+			t.Errorf("expected the back-filled entry to be kept a minute in L1, got %s", d)
+		}
+	})
+
+	t.Run("back-filled ttl", func(t *testing.T) {
+		l1, l2 := &cacherTTLMock{}, newLRUCacher(0)
+		stored := func(key string, age, ttl time.Duration) {
+			q := tieredQuery(1)
+			q.meta.CreatedAt, q.meta.TTL = time.Now().Add(-age), ttl
+			_ = l2.Store(ctx, key, q)
+		}
+		stored("a", 50*time.Second, time.Minute)
+		stored("b", time.Second, time.Hour)
+		stored("expired", 2*time.Minute, time.Minute)
+
+		c := NewTieredCacher(l1, l2, time.Minute)
+		for _, key := range []string{"a", "b", "expired"} {
+			if _, ok := tieredGet(t, c, key); !ok {
+				t.Errorf("expected `%s` to be read from L2", key)
+			}
+		}
+		if d, _ := l1.ttl.Load("a"); d.(time.Duration) > 10*time.Second || d.(time.Duration) < 9*time.Second {
+			t.Errorf("expected the entry to be kept in L1 for the rest of its TTL, got %s", d)
+		}
+		if d, _ := l1.ttl.Load("b"); d != time.Minute {
+			t.Errorf("expected the entry to be kept a minute in L1, got %s", d)
+		}
+		if _, ok := l1.ttl.Load("expired"); ok {
+			t.Error("the expired entry was not expected to be back-filled")
+		}
+
+		l1 = &cacherTTLMock{}
+		c = NewTieredCacher(l1, l2, 0)
+		tieredGet(t, c, "b")
+		if d, _ := l1.ttl.Load("b"); d.(time.Duration) > time.Hour || d.(time.Duration) < 59*time.Minute {
+			t.Errorf("expected the entry to be kept in L1 for the rest of its TTL without L1TTL, got %s", d)
+		}
+	})
+
+	t.Run("expired in L1", func(t *testing.T) {
+		l1, l2 := newLRUCacher(0), newLRUCacher(0)
+		now := time.Now()
+		l1.now = func() time.Time { return now }
+		c := NewTieredCacher(l1, l2, time.Minute)
+
+		_ = c.Store(ctx, "a", lruQuery(1))
+		_ = l2.Store(ctx, "a", lruQuery(2))
+		if val, _ := lruGet(t, c, "a"); val != 1 {
+			t.Errorf("expected 1 to be read from L1, got %d", val)
+		}
+		now = now.Add(2 * time.Minute)
+		if val, _ := lruGet(t, c, "a"); val != 2 {
+			t.Errorf("expected 2 to be read from L2 once expired in L1, got %d", val)
+		}
+	})
+
+	t.Run("invalid entry in L1", func(t *testing.T) {
+		l1, l2 := &cacherBytesMock{}, newLRUCacher(0)
+		c := NewTieredCacher(l1, l2, time.Minute)
+		_ = c.Store(ctx, "a", tieredQuery(1))
+		stored, _ := l1.store.Load("a")
+		corrupted := append([]byte(nil), stored.([]byte)...)
+		corrupted[len(corrupted)-1] ^= 0xff
+		l1.store.Store("a", corrupted)
+
+		if val, ok := tieredGet(t, c, "a"); !ok || val != 1 {
+			t.Errorf("expected 1 to be read from L2, got %d, %t", val, ok)
+		}
+		if val, ok := tieredGet(t, l1, "a"); !ok || val != 1 {
+			t.Errorf("expected the invalid entry to be replaced in L1, got %d, %t", val, ok)
+		}
+	})
+
+	t.Run("invalidation", func(t *testing.T) {
+		l1, l2 := newLRUCacher(0), &cacherTagMock{}
+		c := NewTieredCacher(l1, l2, time.Minute)
+		kept := func(key string) bool {
+			_, ok1 := lruGet(t, l1, key)
+			_, ok2 := lruGet(t, l2, key)
+			if ok1 != ok2 {
+				t.Errorf("`%s` expected to be in both tiers or none, got %t and %t", key, ok1, ok2)
+			}
+			return ok1
+		}
+
+		_ = c.Store(ctx, "a", lruQuery(1, "tag"))
+		_ = c.Store(ctx, "b", lruQuery(2))
+		_ = c.InvalidateTags(ctx, "tag")
+		if kept("a") || !kept("b") {
+			t.Error("expected only the tagged entry to be invalidated")
+		}
+
+		_ = c.Delete(ctx, "b")
+		if kept("b") {
+			t.Error("expected the entry to be deleted")
+		}
+
+		_ = c.Store(ctx, "a", lruQuery(1))
+		_ = c.Invalidate(ctx)
+		if kept("a") {
+			t.Error("expected the entries to be invalidated")
+		}
+	})
+
+	t.Run("not a TagInvalidator", func(t *testing.T) {
+		l1, l2 := newLRUCacher(0), &cacherBytesMock{}
+		c := NewTieredCacher(l1, l2, time.Minute)
+		_ = c.Store(ctx, "a", lruQuery(1, "tag"))
+		_ = c.Store(ctx, "b", lruQuery(2))
+		_ = c.InvalidateTags(ctx, "tag")
+
+		if _, ok := lruGet(t, l2, "b"); ok {
+			t.Error("expected L2 to be invalidated entirely")
+		}
+		if _, ok := lruGet(t, l1, "b"); !ok {
+			t.Error("expected L1 to only be invalidated by tag")
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		c := NewTieredCacher(newLRUCacher(0), &cacherStoreErrorMock{}, time.Minute)
+		if err := c.Store(ctx, "a", lruQuery(1)); err == nil {
+			t.Error("expected the error of L2 to be returned")
+		}
+		if _, ok := lruGet(t, c.L1, "a"); ok {
+			t.Error("the entry was not expected to be stored in L1 when L2 failed")
+		}
+
+		c = NewTieredCacher(newLRUCacher(0), &cacherGetErrorMock{}, time.Minute)
+		if _, err := c.Get(ctx, "a", &Query[any]{}); err == nil || errors.Is(err, ErrInvalidEntry) {
+			t.Errorf("expected the error of L2 to be returned, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package cache
+
+import (
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+)
+
+// AdmissionPolicy decides which entries the in-memory Cachers keep when they are full,
+// look at the WithAdmission method of LRUCacher, BoundedCacher and ShardedCacher.
+// It must be safe for concurrent use
+type AdmissionPolicy interface {
+	// Record is called on every read of a key
+	Record(key string)
+	// Admit reports whether the candidate entry may evict the victim one
+	Admit(candidate, victim string) bool
+}
+
+const tinyLFUDepth = 4
+
+// TinyLFU is an AdmissionPolicy admitting the keys read more often than the ones they would evict,
+// the frequencies are estimated by a count-min sketch, in front of which a doorkeeper
+// keeps the keys read once, and they are halved periodically so that they follow the workload
+type TinyLFU struct {
+	seed maphash.Seed
+	// counters holds tinyLFUDepth rows of width counters
+	counters []uint32
+	mask     uint64
+	// doorkeeper is a bloom filter of the keys read at least once
+	doorkeeper []uint64
+	doorMask   uint64
+
+	additions  atomic.Int64
+	sampleSize int64
+	resetting  sync.Mutex
+}
+
+// NewTinyLFU returns a TinyLFU for a cache of capacity entries
+func NewTinyLFU(capacity int) *TinyLFU {
+	if capacity < 16 {
+		capacity = 16
+	}
+	// 32 bytes of counters and 10 bytes of doorkeeper by entry, to keep collisions seldom
+	width := nextPowerOfTwo(uint64(2 * capacity))
+	sample := 10 * capacity
+	bits := nextPowerOfTwo(uint64(8 * sample))
+	return &TinyLFU{
+		seed:       maphash.MakeSeed(),
+		counters:   make([]uint32, tinyLFUDepth*width),
+		mask:       width - 1,
+		doorkeeper: make([]uint64, bits/64),
+		doorMask:   bits - 1,
+		sampleSize: int64(sample),
+	}
+}
+
+func (t *TinyLFU) Record(key string) {
+	h1, h2 := t.hash(key)
+	if !t.admitDoor(h1, h2) {
+		// Read for the first time since the last reset, the doorkeeper is enough
+		t.added()
+		return
+	}
+	for i := uint64(0); i < tinyLFUDepth; i++ {
+		atomic.AddUint32(&t.counters[i*(t.mask+1)+(h1+i*h2)&t.mask], 1)
+	}
+	t.added()
+}
+
+func (t *TinyLFU) Admit(candidate, victim string) bool {
+	return t.Frequency(candidate) > t.Frequency(victim)
+}
+
+// Frequency returns the estimated number of reads of key since the last reset
+func (t *TinyLFU) Frequency(key string) uint32 {
+	h1, h2 := t.hash(key)
+	freq := ^uint32(0)
+	for i := uint64(0); i < tinyLFUDepth; i++ {
+		freq = min(freq, atomic.LoadUint32(&t.counters[i*(t.mask+1)+(h1+i*h2)&t.mask]))
+	}
+	if t.inDoor(h1, h2) {
+		freq++
+	}
+	return freq
+}
+
+func (t *TinyLFU) hash(key string) (uint64, uint64) {
+	h := maphash.String(t.seed, key)
+	return h, h>>32 | 1
+}
+
+func (t *TinyLFU) inDoor(h1, h2 uint64) bool {
+	for _, bit := range [2]uint64{h1 & t.doorMask, (h1 + h2) & t.doorMask} {
+		if atomic.LoadUint64(&t.doorkeeper[bit/64])&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// admitDoor adds the key to the doorkeeper, reporting whether it was in already
+func (t *TinyLFU) admitDoor(h1, h2 uint64) bool {
+	found := true
+	for _, bit := range [2]uint64{h1 & t.doorMask, (h1 + h2) & t.doorMask} {
+		mask := uint64(1) << (bit % 64)
+		if atomic.OrUint64(&t.doorkeeper[bit/64], mask)&mask == 0 {
+			found = false
+		}
+	}
+	return found
+}
+
+func (t *TinyLFU) added() {
+	if t.additions.Add(1) < t.sampleSize || !t.resetting.TryLock() {
+		return
+	}
+	defer t.resetting.Unlock()
+	if t.additions.Load() < t.sampleSize {
+		return
+	}
+	// Concurrent reads might be missed meanwhile, which is fine for estimations
+	for i := range t.counters {
+		atomic.StoreUint32(&t.counters[i], atomic.LoadUint32(&t.counters[i])/2)
+	}
+	for i := range t.doorkeeper {
+		atomic.StoreUint64(&t.doorkeeper[i], 0)
+	}
+	t.additions.Store(0)
+}
+
+func nextPowerOfTwo(n uint64) uint64 {
+	p := uint64(64)
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
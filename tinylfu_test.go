@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestTinyLFU(t *testing.T) {
+	t.Run("frequency", func(t *testing.T) {
+		p := NewTinyLFU(100)
+		if freq := p.Frequency("a"); freq != 0 {
+			t.Errorf("expected an unknown key to have no frequency, got %d", freq)
+		}
+		for i := 0; i < 5; i++ {
+			p.Record("a")
+		}
+		p.Record("b")
+		if freq := p.Frequency("a"); freq != 5 {
+			t.Errorf("expected a frequency of 5, got %d", freq)
+		}
+		if freq := p.Frequency("b"); freq != 1 {
+			t.Errorf("expected the doorkeeper to count a single read, got %d", freq)
+		}
+		if !p.Admit("a", "b") || p.Admit("b", "a") || p.Admit("b", "b") {
+			t.Error("only keys read more often than their victim were expected to be admitted")
+		}
+	})
+
+	t.Run("aging", func(t *testing.T) {
+		p := NewTinyLFU(16)
+		for i := 0; i < 8; i++ {
+			p.Record("hot")
+		}
+		for i := 0; p.additions.Load() != 0; i++ {
+			p.Record(fmt.Sprintf("key-%d", i))
+		}
+		if freq := p.Frequency("hot"); freq != 3 {
+			t.Errorf("expected the frequencies to be halved, got %d", freq)
+		}
+	})
+}
+
+// zipfKeys returns n keys following a Zipf distribution, among which every tenth is read only once
+func zipfKeys(n int) []string {
+	r := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(r, 1.1, 1, 10000)
+	keys := make([]string, n)
+	for i := range keys {
+		if i%10 == 0 {
+			keys[i] = fmt.Sprintf("once-%d", i)
+			continue
+		}
+		keys[i] = fmt.Sprintf("key-%d", zipf.Uint64())
+	}
+	return keys
+}
+
+// hitRatio reads the keys, storing them on misses as the plugin does
+func hitRatio(c Cacher, keys []string) float64 {
+	ctx := context.Background()
+	hits := 0
+	for _, key := range keys {
+		var dest int
+		if res, _ := c.Get(ctx, key, &Query[any]{Dest: &dest}); res != nil {
+			hits++
+			continue
+		}
+		_ = c.Store(ctx, key, &Query[any]{Dest: 1, RowsAffected: 1})
+	}
+	return float64(hits) / float64(len(keys))
+}
+
+func TestTinyLFU_hitRatio(t *testing.T) {
+	keys := zipfKeys(30000)
+	const capacity = 500
+	budget := int64(capacity * 64)
+
+	for name, tc := range map[string]struct {
+		lfu, lru Cacher
+	}{
+		"lru": {
+			lfu: NewLRUCacher(capacity, 0).WithAdmission(NewTinyLFU(capacity)),
+			lru: NewLRUCacher(capacity, 0),
+		},
+		"sharded": {
+			lfu: NewShardedCacher(4, capacity, 0).WithAdmission(NewTinyLFU(capacity)),
+			lru: NewShardedCacher(4, capacity, 0),
+		},
+		"bounded": {
+			lfu: NewBoundedCacher(budget, 0, 0).WithAdmission(NewTinyLFU(capacity)),
+			lru: NewBoundedCacher(budget, 0, 0),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			lfu, lru := hitRatio(tc.lfu, keys), hitRatio(tc.lru, keys)
+			if lfu <= lru {
+				t.Errorf("expected the admission policy to raise the hit ratio, got %.3f instead of %.3f", lfu, lru)
+			}
+		})
+	}
+}
+
+func TestLRUCacher_WithAdmission(t *testing.T) {
+	ctx := context.Background()
+	c := NewLRUCacher(100, 0).WithAdmission(NewTinyLFU(100))
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("hot-%d", i)
+		for j := 0; j < 3; j++ {
+			lruGet(t, c, key)
+		}
+		_ = c.Store(ctx, key, lruQuery(i))
+	}
+
+	// A scan of keys read once does not push out the hot ones
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("scan-%d", i)
+		lruGet(t, c, key)
+		_ = c.Store(ctx, key, lruQuery(i))
+	}
+	kept := 0
+	for i := 0; i < 100; i++ {
+		if _, ok := lruGet(t, c, fmt.Sprintf("hot-%d", i)); ok {
+			kept++
+		}
+	}
+	if kept < 90 {
+		t.Errorf("expected the hot entries to be kept, got %d of them", kept)
+	}
+	if c.Len() > 100 {
+		t.Errorf("expected at most 100 entries, got %d", c.Len())
+	}
+}
+
+// BenchmarkTinyLFU reports the hit ratio of the in-memory Cachers on a Zipfian workload
+func BenchmarkTinyLFU(b *testing.B) {
+	const capacity = 1000
+	keys := zipfKeys(200000)
+	for _, cacher := range []struct {
+		name string
+		new  func() Cacher
+	}{
+		{"lru", func() Cacher { return NewLRUCacher(capacity, 0) }},
+		{"lru-tinylfu", func() Cacher { return NewLRUCacher(capacity, 0).WithAdmission(NewTinyLFU(capacity)) }},
+		{"bounded", func() Cacher { return NewBoundedCacher(capacity*64, 0, 0) }},
+		{"bounded-tinylfu", func() Cacher {
+			return NewBoundedCacher(capacity*64, 0, 0).WithAdmission(NewTinyLFU(capacity))
+		}},
+	} {
+		b.Run(cacher.name, func(b *testing.B) {
+			var ratio float64
+			for i := 0; i < b.N; i++ {
+				ratio = hitRatio(cacher.new(), keys)
+			}
+			b.ReportMetric(ratio*100, "hit%")
+		})
+	}
+}
@@ -0,0 +1,532 @@
+package cache
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"gorm.io/gorm/schema"
+)
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	valuerType  = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+)
+
+// typedTime keeps the location of a time.Time, which RFC 3339 alone does not
+type typedTime struct {
+	Time string
+	Loc  string
+}
+
+// typedValue is the representation of a value held by an interface,
+// T names its Go type so that it can be restored as is
+type typedValue struct {
+	T string
+	V json.RawMessage
+}
+
+// encodeValue writes v as JSON, using the Go field names of structs and
+// type tags for the values held by interfaces, so that decodeValue
+// can restore it into a destination of the same type without any loss
+func encodeValue(buf *bytes.Buffer, v reflect.Value) error {
+	if !v.IsValid() {
+		buf.WriteString("null")
+		return nil
+	}
+
+	t := v.Type()
+	if t == timeType {
+		return encodeTime(buf, v.Interface().(time.Time))
+	}
+	if isScannable(t) {
+		// Encode it the way it is written to the database,
+		// so that decoding can Scan it the way it is read from it
+		val, err := valueOf(v)
+		if err != nil {
+			return err
+		}
+		return encodeDynamic(buf, val)
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		return encodeValue(buf, v.Elem())
+
+	case reflect.Interface:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		return encodeDynamic(buf, v.Elem().Interface())
+
+	case reflect.Struct:
+		buf.WriteByte('{')
+		first := true
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				// Unexported fields are never populated by gorm either
+				continue
+			}
+			if !first {
+				buf.WriteByte(',')
+			}
+			first = false
+			writeJSON(buf, t.Field(i).Name)
+			buf.WriteByte(':')
+			if err := encodeValue(buf, v.Field(i)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+
+	case reflect.Map:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		keys := v.MapKeys()
+		if t.Key().Kind() != reflect.String {
+			// Encode as a list of key / value pairs
+			buf.WriteByte('[')
+			for i, key := range keys {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				buf.WriteByte('[')
+				if err := encodeValue(buf, key); err != nil {
+					return err
+				}
+				buf.WriteByte(',')
+				if err := encodeValue(buf, v.MapIndex(key)); err != nil {
+					return err
+				}
+				buf.WriteByte(']')
+			}
+			buf.WriteByte(']')
+			return nil
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeJSON(buf, key.String())
+			buf.WriteByte(':')
+			if err := encodeValue(buf, v.MapIndex(key)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+
+	case reflect.Slice:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			return writeJSON(buf, v.Bytes())
+		}
+		fallthrough
+	case reflect.Array:
+		buf.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeValue(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer, reflect.Complex64, reflect.Complex128:
+		return fmt.Errorf("%w: %s", schema.ErrUnsupportedDataType, t)
+
+	default:
+		return writeJSON(buf, v.Interface())
+	}
+	return nil
+}
+
+// encodeDynamic writes a value held by an interface along with its type
+func encodeDynamic(buf *bytes.Buffer, val any) error {
+	var name string
+	switch val.(type) {
+	case nil:
+		buf.WriteString("null")
+		return nil
+	case bool:
+		name = "bool"
+	case int:
+		name = "int"
+	case int8:
+		name = "int8"
+	case int16:
+		name = "int16"
+	case int32:
+		name = "int32"
+	case int64:
+		name = "int64"
+	case uint:
+		name = "uint"
+	case uint8:
+		name = "uint8"
+	case uint16:
+		name = "uint16"
+	case uint32:
+		name = "uint32"
+	case uint64:
+		name = "uint64"
+	case float32:
+		name = "float32"
+	case float64:
+		name = "float64"
+	case string:
+		name = "string"
+	case []byte:
+		name = "bytes"
+	case time.Time:
+		name = "time"
+	case map[string]any:
+		name = "map"
+	case []any:
+		name = "slice"
+	default:
+		// Its type can not be named here, it is restored
+		// into the destination held by the interface if any
+		name = "any"
+	}
+
+	buf.WriteString(`{"T":`)
+	writeJSON(buf, name)
+	buf.WriteString(`,"V":`)
+	if err := encodeValue(buf, reflect.ValueOf(val)); err != nil {
+		return err
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+func encodeTime(buf *bytes.Buffer, t time.Time) error {
+	return writeJSON(buf, typedTime{
+		Time: t.Format(time.RFC3339Nano),
+		Loc:  t.Location().String(),
+	})
+}
+
+func writeJSON(buf *bytes.Buffer, val any) error {
+	bytes, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	buf.Write(bytes)
+	return nil
+}
+
+// decodeValue restores data written by encodeValue into v, which must be settable
+func decodeValue(data json.RawMessage, v reflect.Value) error {
+	t := v.Type()
+	null := isNull(data)
+
+	if t == timeType {
+		if null {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		tm, err := decodeTime(data)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(tm))
+		return nil
+	}
+	if isScannable(t) {
+		val, err := decodeDynamic(data)
+		if err != nil {
+			return err
+		}
+		ptr := reflect.New(t)
+		if err := ptr.Interface().(sql.Scanner).Scan(val); err != nil {
+			return err
+		}
+		v.Set(ptr.Elem())
+		return nil
+	}
+	if null {
+		v.Set(reflect.Zero(t))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		return decodeValue(data, v.Elem())
+
+	case reflect.Interface:
+		return decodeInterface(data, v)
+
+	case reflect.Struct:
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return err
+		}
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				continue
+			}
+			raw, ok := fields[t.Field(i).Name]
+			if !ok {
+				continue
+			}
+			if err := decodeValue(raw, v.Field(i)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(t))
+		}
+		return decodeMap(data, v)
+
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// Encoded as raw bytes, the UnmarshalJSON of named types such as json.RawMessage is bypassed too
+			var b []byte
+			if err := json.Unmarshal(data, &b); err != nil {
+				return err
+			}
+			v.SetBytes(b)
+			return nil
+		}
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return err
+		}
+		slice := reflect.MakeSlice(t, len(items), len(items))
+		for i, item := range items {
+			if err := decodeValue(item, slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+
+	case reflect.Array:
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return err
+		}
+		for i := 0; i < len(items) && i < v.Len(); i++ {
+			if err := decodeValue(items[i], v.Index(i)); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return json.Unmarshal(data, v.Addr().Interface())
+	}
+	return nil
+}
+
+// decodeInterface restores a value written by encodeDynamic,
+// into the destination held by the interface when there is one
+func decodeInterface(data json.RawMessage, v reflect.Value) error {
+	if !v.IsNil() {
+		held := v.Elem()
+		if (held.Kind() == reflect.Ptr || held.Kind() == reflect.Map) && !held.IsNil() {
+			var tv typedValue
+			if err := json.Unmarshal(data, &tv); err != nil {
+				return err
+			}
+			if held.Kind() == reflect.Map {
+				return decodeMap(tv.V, held)
+			}
+			return decodeValue(tv.V, held.Elem())
+		}
+	}
+
+	val, err := decodeDynamic(data)
+	if err != nil {
+		return err
+	}
+	if val == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	v.Set(reflect.ValueOf(val))
+	return nil
+}
+
+func decodeMap(data json.RawMessage, v reflect.Value) error {
+	t := v.Type()
+	if t.Key().Kind() != reflect.String {
+		var pairs [][2]json.RawMessage
+		if err := json.Unmarshal(data, &pairs); err != nil {
+			return err
+		}
+		for _, pair := range pairs {
+			key := reflect.New(t.Key()).Elem()
+			if err := decodeValue(pair[0], key); err != nil {
+				return err
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			if err := decodeValue(pair[1], elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+		}
+		return nil
+	}
+
+	var entries map[string]json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	for name, raw := range entries {
+		elem := reflect.New(t.Elem()).Elem()
+		if err := decodeValue(raw, elem); err != nil {
+			return err
+		}
+		v.SetMapIndex(reflect.ValueOf(name).Convert(t.Key()), elem)
+	}
+	return nil
+}
+
+// decodeDynamic restores a value written by encodeDynamic to its original type
+func decodeDynamic(data json.RawMessage) (any, error) {
+	if isNull(data) {
+		return nil, nil
+	}
+	var tv typedValue
+	if err := json.Unmarshal(data, &tv); err != nil {
+		return nil, err
+	}
+
+	var ptr any
+	switch tv.T {
+	case "bool":
+		ptr = new(bool)
+	case "int":
+		ptr = new(int)
+	case "int8":
+		ptr = new(int8)
+	case "int16":
+		ptr = new(int16)
+	case "int32":
+		ptr = new(int32)
+	case "int64":
+		ptr = new(int64)
+	case "uint":
+		ptr = new(uint)
+	case "uint8":
+		ptr = new(uint8)
+	case "uint16":
+		ptr = new(uint16)
+	case "uint32":
+		ptr = new(uint32)
+	case "uint64":
+		ptr = new(uint64)
+	case "float32":
+		ptr = new(float32)
+	case "float64":
+		ptr = new(float64)
+	case "string":
+		ptr = new(string)
+	case "bytes":
+		ptr = new([]byte)
+	case "time":
+		ptr = new(time.Time)
+	case "map":
+		ptr = new(map[string]any)
+	case "slice":
+		ptr = new([]any)
+	case "any":
+		var val any
+		if err := json.Unmarshal(tv.V, &val); err != nil {
+			return nil, err
+		}
+		return val, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", schema.ErrUnsupportedDataType, tv.T)
+	}
+
+	if err := decodeValue(tv.V, reflect.ValueOf(ptr).Elem()); err != nil {
+		return nil, err
+	}
+	return reflect.ValueOf(ptr).Elem().Interface(), nil
+}
+
+func decodeTime(data json.RawMessage) (time.Time, error) {
+	var tm time.Time
+	if data[0] == '"' {
+		// Plain RFC 3339, as written by encoding/json
+		err := json.Unmarshal(data, &tm)
+		return tm, err
+	}
+
+	var tt typedTime
+	if err := json.Unmarshal(data, &tt); err != nil {
+		return tm, err
+	}
+	tm, err := time.Parse(time.RFC3339Nano, tt.Time)
+	if err != nil {
+		return tm, err
+	}
+
+	_, offset := tm.Zone()
+	switch tt.Loc {
+	case "UTC":
+		return tm.UTC(), nil
+	case "Local":
+		if _, off := tm.Local().Zone(); off == offset {
+			return tm.Local(), nil
+		}
+	case "":
+	default:
+		if loc, err := time.LoadLocation(tt.Loc); err == nil {
+			if _, off := tm.In(loc).Zone(); off == offset {
+				return tm.In(loc), nil
+			}
+		}
+	}
+	return tm.In(time.FixedZone(tt.Loc, offset)), nil
+}
+
+// isScannable reports whether t is read from the database through sql.Scanner
+// and written to it through driver.Valuer
+func isScannable(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface {
+		return false
+	}
+	ptr := reflect.PointerTo(t)
+	return ptr.Implements(scannerType) && ptr.Implements(valuerType)
+}
+
+func valueOf(v reflect.Value) (driver.Value, error) {
+	if v.Type().Implements(valuerType) {
+		return v.Interface().(driver.Valuer).Value()
+	}
+	ptr := reflect.New(v.Type())
+	ptr.Elem().Set(v)
+	return ptr.Interface().(driver.Valuer).Value()
+}
+
+func isNull(data json.RawMessage) bool {
+	return len(data) == 0 || string(data) == "null"
+}
@@ -0,0 +1,224 @@
+package cache
+
+import (
+	"database/sql"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+type typedProfile struct {
+	Bio     sql.NullString
+	Website *string
+}
+
+type typedUser struct {
+	gorm.Model
+	Name     string
+	Age      int64
+	Score    float64
+	Active   bool
+	Avatar   []byte
+	Nickname sql.NullString
+	Birthday *time.Time
+	Profile  typedProfile
+	Tags     []string
+	Extra    map[string]any
+	Document json.RawMessage
+	secret   string
+}
+
+func typedFixtures(t *testing.T) map[string]any {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	website := "https://example.com"
+	birthday := time.Date(1990, 5, 17, 0, 0, 0, 0, time.UTC)
+	user := typedUser{
+		Model: gorm.Model{
+			ID:        7,
+			CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 6, time.Local),
+			UpdatedAt: time.Date(2024, 1, 2, 3, 4, 5, 6, berlin),
+			DeletedAt: gorm.DeletedAt{Time: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), Valid: true},
+		},
+		Name:     "ktsivkov",
+		Age:      1 << 60,
+		Score:    12.5,
+		Active:   true,
+		Avatar:   []byte{0, 1, 2},
+		Nickname: sql.NullString{String: "kt", Valid: true},
+		Birthday: &birthday,
+		Profile:  typedProfile{Website: &website},
+		Tags:     []string{},
+		Extra:    map[string]any{"level": int64(3)},
+		Document: json.RawMessage(`{"x":1}`),
+	}
+
+	return map[string]any{
+		"struct":       &user,
+		"empty struct": &typedUser{},
+		"slice":        &[]typedUser{user, {Name: "other"}},
+		"empty slice":  &[]typedUser{},
+		"nil slice":    new([]typedUser),
+		"map": &map[string]any{
+			"id":         int64(1),
+			"name":       "ktsivkov",
+			"price":      9.99,
+			"active":     true,
+			"blob":       []byte("blob"),
+			"created_at": time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			"deleted_at": nil,
+		},
+		"slice of maps": &[]map[string]any{
+			{"id": int64(1), "amount": int32(5)},
+			{"id": int64(2), "amount": uint64(1<<64 - 1)},
+		},
+		"scalar":         new(int64),
+		"scalar time":    &user.CreatedAt,
+		"pluck":          &[]string{"a", "b"},
+		"non-string key": &map[int64]string{1: "a", 2: "b"},
+		"fixed zone":     &[]time.Time{time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("CST", 8*3600))},
+	}
+}
+
+func TestQuery_typeFidelity(t *testing.T) {
+	for name, expected := range typedFixtures(t) {
+		t.Run(name, func(t *testing.T) {
+			bytes, err := (&Query[any]{Dest: expected, RowsAffected: 2}).Marshal()
+			if err != nil {
+				t.Fatalf("Marshal resulted to an unexpected error. %v", err)
+			}
+
+			actual := &Query[any]{Dest: reflect.New(reflect.TypeOf(expected).Elem()).Interface()}
+			if err := actual.Unmarshal(bytes); err != nil {
+				t.Fatalf("Unmarshal resulted to an unexpected error. %v", err)
+			}
+
+			if actual.RowsAffected != 2 {
+				t.Errorf("Unmarshal was expected to restore the affected rows, got %d", actual.RowsAffected)
+			}
+			assertSameValue(t, reflect.ValueOf(expected), reflect.ValueOf(actual.Dest))
+		})
+	}
+
+	t.Run("unexported fields are left untouched", func(t *testing.T) {
+		bytes, err := (&Query[any]{Dest: &typedUser{Name: "ktsivkov", secret: "cached"}}).Marshal()
+		if err != nil {
+			t.Fatalf("Marshal resulted to an unexpected error. %v", err)
+		}
+
+		dest := &typedUser{secret: "kept"}
+		if err := (&Query[any]{Dest: dest}).Unmarshal(bytes); err != nil {
+			t.Fatalf("Unmarshal resulted to an unexpected error. %v", err)
+		}
+		if dest.Name != "ktsivkov" || dest.secret != "kept" {
+			t.Errorf("Unmarshal was expected to only restore exported fields, got %+v", dest)
+		}
+	})
+}
+
+func TestCaches_hitMatchesDatabaseRead(t *testing.T) {
+	for name, expected := range typedFixtures(t) {
+		t.Run(name, func(t *testing.T) {
+			caches := &Caches{
+				Conf: &Config{Cacher: &cacherBytesMock{}},
+				callbacks: map[queryType]func(db *gorm.DB){
+					uponQuery: func(db *gorm.DB) {
+						// Acts as a database read
+						reflect.ValueOf(db.Statement.Dest).Elem().Set(reflect.ValueOf(expected).Elem())
+						db.Statement.RowsAffected = 1
+					},
+				},
+			}
+
+			run := func() *gorm.DB {
+				db, _ := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+				db.Statement.Dest = reflect.New(reflect.TypeOf(expected).Elem()).Interface()
+				db.Statement.SQL.WriteString("demo-query")
+				caches.query(db)
+				if db.Error != nil {
+					t.Fatalf("an unexpected error has occurred, %v", db.Error)
+				}
+				return db
+			}
+
+			read, hit := run(), run()
+			assertSameValue(t, reflect.ValueOf(read.Statement.Dest), reflect.ValueOf(hit.Statement.Dest))
+			if read.Statement.RowsAffected != hit.Statement.RowsAffected {
+				t.Errorf("a cache hit was expected to affect %d rows, got %d", read.Statement.RowsAffected, hit.Statement.RowsAffected)
+			}
+		})
+	}
+}
+
+// assertSameValue is reflect.DeepEqual, except that locations
+// of time.Time values are compared by name and offset
+func assertSameValue(t *testing.T, expected, actual reflect.Value) {
+	t.Helper()
+	if !sameValue(expected, actual) {
+		t.Errorf("expected %#v, got %#v", expected.Interface(), actual.Interface())
+	}
+}
+
+func sameValue(expected, actual reflect.Value) bool {
+	if expected.IsValid() != actual.IsValid() {
+		return false
+	}
+	if !expected.IsValid() {
+		return true
+	}
+	if expected.Type() != actual.Type() {
+		return false
+	}
+	if expected.Type() == timeType {
+		e, a := expected.Interface().(time.Time), actual.Interface().(time.Time)
+		en, eo := e.Zone()
+		an, ao := a.Zone()
+		return e.Equal(a) && en == an && eo == ao && e.Location().String() == a.Location().String()
+	}
+
+	switch expected.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if expected.IsNil() || actual.IsNil() {
+			return expected.IsNil() == actual.IsNil()
+		}
+		return sameValue(expected.Elem(), actual.Elem())
+	case reflect.Struct:
+		for i := 0; i < expected.NumField(); i++ {
+			if expected.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			if !sameValue(expected.Field(i), actual.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Slice, reflect.Map:
+		if expected.IsNil() != actual.IsNil() || expected.Len() != actual.Len() {
+			return false
+		}
+		if expected.Kind() == reflect.Map {
+			for _, key := range expected.MapKeys() {
+				if !sameValue(expected.MapIndex(key), actual.MapIndex(key)) {
+					return false
+				}
+			}
+			return true
+		}
+		fallthrough
+	case reflect.Array:
+		for i := 0; i < expected.Len(); i++ {
+			if !sameValue(expected.Index(i), actual.Index(i)) {
+				return false
+			}
+		}
+		return true
+	default:
+		return expected.Interface() == actual.Interface()
+	}
+}